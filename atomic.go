@@ -0,0 +1,127 @@
+package testio
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+// AtomicWriteTest describes a function that should replace a file's
+// contents atomically, for TestAtomicWrite.
+type AtomicWriteTest struct {
+	// Name is the name of the file being replaced.
+	Name string
+
+	// Old is the file's original contents. If it is nil, the file
+	// doesn't exist to start with.
+	Old []byte
+
+	// New is the contents that Save should write.
+	New []byte
+
+	// Save writes data to the named file in fs. It is called many
+	// times, with a fresh filesystem each time.
+	Save func(fs FS, name string, data []byte) error
+
+	// Check, if set, is called with the filesystem after every run
+	// of Save that didn't end in a crash, to check any other
+	// invariants; for example, that no temporary files are left
+	// behind.
+	Check func(fs *MemFS) error
+}
+
+// TestAtomicWrite checks that at.Save replaces a file atomically: that
+// however it fails, the file holds either the old or the new contents
+// and never anything in between, both after Save returns and after a
+// crash. If Save reports success, the new contents must survive a
+// crash.
+//
+// Save is first run without any failures, when it must succeed, to
+// count the bytes it writes and the filesystem operations it makes.
+// It is then run again
+// with the filesystem failing at every byte offset and at every
+// operation, both as a one-off error and as a crash, after which
+// every operation fails. The test stops at the first problem.
+func TestAtomicWrite(t testing.TB, at AtomicWriteTest) {
+	t.Helper()
+
+	fs, err := at.run(t, "without failures", func(*MemFS) {})
+	if err != nil {
+		t.Fatalf("without failures, Save failed: %v", err)
+	}
+	written, ops := fs.BytesWritten(), fs.Ops()
+
+	for n := 0; n < written; n++ {
+		at.run(t, fmt.Sprintf("with the disk full after %d bytes", n), func(fs *MemFS) {
+			fs.SetByteLimit(n)
+		})
+		at.run(t, fmt.Sprintf("with a crash after %d bytes", n), func(fs *MemFS) {
+			fs.crashOnLimit = true
+			fs.SetByteLimit(n)
+		})
+	}
+
+	for n := 0; n < ops; n++ {
+		at.run(t, fmt.Sprintf("with operation %d failing", n), func(fs *MemFS) {
+			fs.FailOp(n)
+		})
+		at.run(t, fmt.Sprintf("with a crash after %d operations", n), func(fs *MemFS) {
+			fs.crashOnLimit = true
+			fs.SetOpLimit(n)
+		})
+	}
+}
+
+// run runs Save once on a filesystem holding the old contents, after
+// calling setup to arrange a failure, and checks the outcome. It
+// returns the filesystem and the error from Save.
+func (at AtomicWriteTest) run(t testing.TB, desc string, setup func(*MemFS)) (*MemFS, error) {
+	t.Helper()
+
+	fs := NewMemFS()
+	if at.Old != nil {
+		fs.WriteFile(at.Name, at.Old)
+	}
+
+	setup(fs)
+	allowed := [][]byte{at.Old, at.New}
+	outcome := "failed"
+	saveErr := at.Save(fs, at.Name, at.New)
+	if saveErr == nil {
+		allowed = allowed[1:]
+		outcome = "succeeded"
+	}
+
+	if err := at.expect(fs, allowed); err != nil {
+		t.Fatalf("%s, after Save %s: %v", desc, outcome, err)
+	}
+	if err := at.expect(fs.Crash(), allowed); err != nil {
+		t.Fatalf("%s, after Save %s and a crash: %v", desc, outcome, err)
+	}
+
+	if at.Check != nil && !fs.crashed {
+		if err := at.Check(fs); err != nil {
+			t.Fatalf("%s, after Save %s: %v", desc, outcome, err)
+		}
+	}
+	return fs, saveErr
+}
+
+// expect checks that the file in fs holds one of the allowed contents,
+// where nil means that it doesn't exist.
+func (at AtomicWriteTest) expect(fs *MemFS, allowed [][]byte) error {
+	data, err := fs.ReadFile(at.Name)
+	for _, want := range allowed {
+		if (want == nil) != (err != nil) {
+			continue
+		}
+		if bytes.Equal(data, want) {
+			return nil
+		}
+	}
+
+	if err != nil {
+		return fmt.Errorf("%s doesn't exist", at.Name)
+	}
+	return fmt.Errorf("%s contains %q, expected %q", at.Name, data, allowed)
+}
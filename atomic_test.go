@@ -0,0 +1,123 @@
+package testio
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// saveAtomic replaces a file by writing a temporary file, syncing it,
+// and renaming it over the original.
+func saveAtomic(fs FS, name string, data []byte) error {
+	tmp := name + ".tmp"
+	f, err := fs.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = fs.Rename(tmp, name)
+	}
+
+	if err != nil {
+		fs.Remove(tmp)
+	}
+	return err
+}
+
+// saveInPlace overwrites the file directly.
+func saveInPlace(fs FS, name string, data []byte) error {
+	f, err := fs.Create(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err = f.Write(data); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// saveWithoutSync is like saveAtomic, but doesn't sync the temporary
+// file before renaming it.
+func saveWithoutSync(fs FS, name string, data []byte) error {
+	tmp := name + ".tmp"
+	f, err := fs.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	f.Close()
+	return fs.Rename(tmp, name)
+}
+
+func noTempFiles(fs *MemFS) error {
+	for _, name := range fs.Names() {
+		if strings.HasSuffix(name, ".tmp") {
+			return fmt.Errorf("%s was left behind", name)
+		}
+	}
+	return nil
+}
+
+func TestAtomicWriteHarness(t *testing.T) {
+	for _, old := range [][]byte{nil, []byte("old contents")} {
+		TestAtomicWrite(t, AtomicWriteTest{
+			Name:  "config",
+			Old:   old,
+			New:   []byte("new contents"),
+			Save:  saveAtomic,
+			Check: noTempFiles,
+		})
+	}
+
+	failure := expectFailure(t, func(tb testing.TB) {
+		TestAtomicWrite(tb, AtomicWriteTest{
+			Name: "config",
+			Old:  []byte("old contents"),
+			New:  []byte("new contents"),
+			Save: saveInPlace,
+		})
+	})
+	if !strings.Contains(failure, "after 0 bytes") {
+		t.Fatalf("expected an in-place write to fail, have '%s'", failure)
+	}
+
+	failure = expectFailure(t, func(tb testing.TB) {
+		TestAtomicWrite(tb, AtomicWriteTest{
+			Name: "config",
+			Old:  []byte("old contents"),
+			New:  []byte("new contents"),
+			Save: saveWithoutSync,
+		})
+	})
+	if !strings.Contains(failure, "succeeded and a crash") {
+		t.Fatalf("expected a missing sync to be caught, have '%s'", failure)
+	}
+
+	failure = expectFailure(t, func(tb testing.TB) {
+		TestAtomicWrite(tb, AtomicWriteTest{
+			Name: "config",
+			Old:  []byte("old contents"),
+			New:  []byte("new contents"),
+			Save: func(FS, string, []byte) error {
+				return errors.New("not implemented")
+			},
+		})
+	})
+	if !strings.Contains(failure, "without failures, Save failed") {
+		t.Fatalf("expected a Save that always fails to be caught, have '%s'", failure)
+	}
+}
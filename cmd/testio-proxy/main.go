@@ -0,0 +1,107 @@
+// Command testio-proxy is a TCP proxy that injects faults into the
+// connections passing through it, for testing clients and servers
+// that talk over real sockets:
+//
+//	testio-proxy -listen localhost:15432 -target localhost:5432 -latency 50ms
+//
+// The faults can be given with flags, or in a JSON file named by
+// -config, in the format read by testio.LoadProxyConfig:
+//
+//	{"latency": "50ms", "bytes_per_second": 65536, "reset_rate": 0.01}
+//
+// YAML files aren't supported, so that the command has no
+// dependencies outside the standard library. Flags override the
+// settings in the file. Sending the proxy SIGHUP rereads the file,
+// and the new settings apply to live connections as well as new ones.
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/kisom/testio"
+)
+
+var (
+	listen      = flag.String("listen", "localhost:0", "address to listen on")
+	target      = flag.String("target", "", "address to forward connections to")
+	configPath  = flag.String("config", "", "JSON configuration file (YAML isn't supported)")
+	latency     = flag.Duration("latency", 0, "delay for each chunk of data")
+	bandwidth   = flag.Int("bandwidth", 0, "bytes per second in each direction")
+	resetRate   = flag.Float64("reset-rate", 0, "probability of resetting the connection on each chunk")
+	resetAfter  = flag.Int("reset-after", 0, "reset connections after this many bytes in one direction")
+	corruptRate = flag.Float64("corrupt-rate", 0, "probability of flipping a bit in each byte")
+	logData     = flag.Bool("log", false, "log the data forwarded to standard error")
+	seed        = flag.Int64("seed", 0, "seed for random resets and corruption")
+)
+
+// loadConfig reads the configuration file, if there is one, and
+// applies any flags that were set.
+func loadConfig() (testio.ProxyConfig, error) {
+	var cfg testio.ProxyConfig
+	if *configPath != "" {
+		var err error
+		if cfg, err = testio.LoadProxyConfig(*configPath); err != nil {
+			return cfg, err
+		}
+	}
+
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "latency":
+			cfg.Latency = *latency
+		case "bandwidth":
+			cfg.BytesPerSecond = *bandwidth
+		case "reset-rate":
+			cfg.ResetRate = *resetRate
+		case "reset-after":
+			cfg.ResetAfter = *resetAfter
+		case "corrupt-rate":
+			cfg.CorruptRate = *corruptRate
+		case "log":
+			cfg.Log = *logData
+		case "seed":
+			cfg.Seed = *seed
+		}
+	})
+	return cfg, nil
+}
+
+func main() {
+	flag.Parse()
+	if *target == "" {
+		log.Fatal("testio-proxy: -target is required")
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Fatalf("testio-proxy: %v", err)
+	}
+
+	proxy, err := testio.NewProxy(*listen, *target, cfg)
+	if err != nil {
+		log.Fatalf("testio-proxy: %v", err)
+	}
+	log.Printf("testio-proxy: forwarding %s to %s", proxy.Addr(), *target)
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+	for sig := range sigs {
+		if sig != syscall.SIGHUP {
+			break
+		}
+
+		cfg, err := loadConfig()
+		if err != nil {
+			log.Printf("testio-proxy: not reloading: %v", err)
+			continue
+		}
+		proxy.SetConfig(cfg)
+		log.Printf("testio-proxy: reloaded configuration")
+	}
+
+	proxy.Close()
+}
@@ -0,0 +1,414 @@
+package testio
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+// The functions in this file are conformance suites for io
+// implementations. Each one takes a factory that returns a fresh value
+// for every check, so that the checks don't depend on each other.
+
+// readSizes are the buffer sizes used when reading through a reader
+// under test.
+var readSizes = []int{1, 3, 7, 512, 4096}
+
+// readChecked reads r until io.EOF using a buffer of size bytes,
+// failing the test if any call violates the io.Reader contract.
+func readChecked(t *testing.T, r io.Reader, size int) []byte {
+	var out []byte
+	var zeros int
+	p := make([]byte, size)
+	for {
+		n, err := r.Read(p)
+		if n < 0 || n > len(p) {
+			t.Fatalf("read returned %d bytes for a %d byte buffer", n, len(p))
+		}
+		out = append(out, p[:n]...)
+
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("read failed after %d bytes: %v", len(out), err)
+		}
+
+		if n == 0 {
+			zeros++
+			if zeros > 100 {
+				t.Fatalf("too many zero-byte reads with a nil error")
+			}
+		}
+	}
+
+	n, err := r.Read(p)
+	if n != 0 || err != io.EOF {
+		t.Fatalf("expected (0, EOF) after EOF, have (%d, %v)", n, err)
+	}
+	return out
+}
+
+// TestReader checks that the io.Reader returned by newReader obeys the
+// io.Reader contract and yields expected before returning io.EOF.
+func TestReader(t *testing.T, newReader func() io.Reader, expected []byte) {
+	t.Run("ReadAll", func(t *testing.T) {
+		for _, size := range readSizes {
+			data := readChecked(t, newReader(), size)
+			if !bytes.Equal(data, expected) {
+				t.Fatalf("buffer size %d: expected %x, have %x",
+					size, expected, data)
+			}
+		}
+	})
+
+	t.Run("ZeroLength", func(t *testing.T) {
+		r := newReader()
+		n, _ := r.Read(nil)
+		if n != 0 {
+			t.Fatalf("zero-length read returned %d bytes", n)
+		}
+
+		n, _ = r.Read([]byte{})
+		if n != 0 {
+			t.Fatalf("zero-length read returned %d bytes", n)
+		}
+
+		data := readChecked(t, r, 4096)
+		if !bytes.Equal(data, expected) {
+			t.Fatalf("zero-length read consumed data: expected %x, have %x",
+				expected, data)
+		}
+	})
+
+	t.Run("WriteTo", func(t *testing.T) {
+		if _, ok := newReader().(io.WriterTo); !ok {
+			t.Skip("reader doesn't implement io.WriterTo")
+		}
+
+		buf := &bytes.Buffer{}
+		wt := newReader().(io.WriterTo)
+		n, err := wt.WriteTo(NewChunkedWriter(buf, 3))
+		if err != nil {
+			t.Fatalf("%v", err)
+		} else if n != int64(len(expected)) {
+			t.Fatalf("expected WriteTo to write %d bytes, have %d",
+				len(expected), n)
+		} else if !bytes.Equal(buf.Bytes(), expected) {
+			t.Fatalf("expected %x, have %x", expected, buf.Bytes())
+		}
+
+		if len(expected) == 0 {
+			return
+		}
+
+		limit := len(expected) / 2
+		wt = newReader().(io.WriterTo)
+		n, err = wt.WriteTo(NewBrokenWriter(limit))
+		if err == nil {
+			t.Fatal("expected WriteTo to report the write failure")
+		} else if n != int64(limit) {
+			t.Fatalf("expected WriteTo to report %d bytes written, have %d",
+				limit, n)
+		}
+	})
+}
+
+// checkedWriter wraps a writer under test, failing the test if any
+// call violates the io.Writer contract.
+type checkedWriter struct {
+	t *testing.T
+	w io.Writer
+}
+
+func (cw *checkedWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	if n < 0 || n > len(p) {
+		cw.t.Fatalf("write returned %d bytes for a %d byte buffer", n, len(p))
+	} else if n < len(p) && err == nil {
+		cw.t.Fatalf("short write of %d/%d bytes returned a nil error",
+			n, len(p))
+	}
+	return n, err
+}
+
+// conformanceData returns n bytes of test data.
+func conformanceData(n int) []byte {
+	p := make([]byte, n)
+	for i := range p {
+		p[i] = byte(i)
+	}
+	return p
+}
+
+// TestWriter checks that the io.Writer returned by newWriter obeys the
+// io.Writer contract. newWriter also returns a function that returns
+// everything written to the writer so far.
+func TestWriter(t *testing.T, newWriter func() (io.Writer, func() []byte)) {
+	data := conformanceData(1000)
+
+	t.Run("Write", func(t *testing.T) {
+		for _, size := range readSizes {
+			w, contents := newWriter()
+			cw := NewChunkedWriter(&checkedWriter{t: t, w: w}, size)
+			n, err := cw.Write(data)
+			if err != nil {
+				t.Fatalf("%v", err)
+			} else if n != len(data) {
+				t.Fatalf("expected to write %d bytes, have %d", len(data), n)
+			}
+
+			if !bytes.Equal(contents(), data) {
+				t.Fatalf("chunk size %d: expected %x, have %x",
+					size, data, contents())
+			}
+		}
+	})
+
+	t.Run("ZeroLength", func(t *testing.T) {
+		w, contents := newWriter()
+		n, err := w.Write([]byte{})
+		if err != nil {
+			t.Fatalf("%v", err)
+		} else if n != 0 {
+			t.Fatalf("zero-length write returned %d bytes", n)
+		}
+
+		if len(contents()) != 0 {
+			t.Fatalf("zero-length write stored %x", contents())
+		}
+	})
+
+	t.Run("NoModify", func(t *testing.T) {
+		w, _ := newWriter()
+		p := conformanceData(len(data))
+		_, err := w.Write(p)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		if !bytes.Equal(p, data) {
+			t.Fatal("writer modified the buffer passed to Write")
+		}
+	})
+
+	t.Run("NoRetain", func(t *testing.T) {
+		w, contents := newWriter()
+		p := conformanceData(len(data))
+		_, err := w.Write(p)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		for i := range p {
+			p[i] = 0
+		}
+
+		if !bytes.Equal(contents(), data) {
+			t.Fatal("writer retained the buffer passed to Write")
+		}
+	})
+
+	t.Run("ReadFrom", func(t *testing.T) {
+		w, contents := newWriter()
+		rf, ok := w.(io.ReaderFrom)
+		if !ok {
+			t.Skip("writer doesn't implement io.ReaderFrom")
+		}
+
+		limit := len(data) / 2
+		n, err := rf.ReadFrom(NewBrokenReader(bytes.NewReader(data), limit))
+		if err == nil {
+			t.Fatal("expected ReadFrom to report the read failure")
+		} else if n != int64(limit) {
+			t.Fatalf("expected ReadFrom to report %d bytes read, have %d",
+				limit, n)
+		}
+
+		if !bytes.Equal(contents(), data[:limit]) {
+			t.Fatalf("expected %x, have %x", data[:limit], contents())
+		}
+	})
+}
+
+// TestSeeker checks that the io.ReadSeeker returned by newSeeker obeys
+// the io.Seeker contract for a stream containing expected.
+func TestSeeker(t *testing.T, newSeeker func() io.ReadSeeker, expected []byte) {
+	size := int64(len(expected))
+
+	t.Run("SeekStart", func(t *testing.T) {
+		for _, off := range []int64{0, size / 2, size} {
+			s := newSeeker()
+			pos, err := s.Seek(off, io.SeekStart)
+			if err != nil {
+				t.Fatalf("%v", err)
+			} else if pos != off {
+				t.Fatalf("expected offset %d, have %d", off, pos)
+			}
+
+			data := readChecked(t, s, 4096)
+			if !bytes.Equal(data, expected[off:]) {
+				t.Fatalf("offset %d: expected %x, have %x",
+					off, expected[off:], data)
+			}
+		}
+	})
+
+	t.Run("SeekCurrent", func(t *testing.T) {
+		s := newSeeker()
+		half := size / 2
+		_, err := io.ReadFull(s, make([]byte, half))
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		pos, err := s.Seek(0, io.SeekCurrent)
+		if err != nil {
+			t.Fatalf("%v", err)
+		} else if pos != half {
+			t.Fatalf("expected offset %d, have %d", half, pos)
+		}
+
+		if half == 0 {
+			return
+		}
+
+		pos, err = s.Seek(-1, io.SeekCurrent)
+		if err != nil {
+			t.Fatalf("%v", err)
+		} else if pos != half-1 {
+			t.Fatalf("expected offset %d, have %d", half-1, pos)
+		}
+
+		data := readChecked(t, s, 4096)
+		if !bytes.Equal(data, expected[half-1:]) {
+			t.Fatalf("expected %x, have %x", expected[half-1:], data)
+		}
+	})
+
+	t.Run("SeekEnd", func(t *testing.T) {
+		s := newSeeker()
+		pos, err := s.Seek(0, io.SeekEnd)
+		if err != nil {
+			t.Fatalf("%v", err)
+		} else if pos != size {
+			t.Fatalf("expected offset %d, have %d", size, pos)
+		}
+
+		n, err := s.Read(make([]byte, 1))
+		if n != 0 || err != io.EOF {
+			t.Fatalf("expected (0, EOF) at the end, have (%d, %v)", n, err)
+		}
+	})
+
+	t.Run("Negative", func(t *testing.T) {
+		s := newSeeker()
+		_, err := s.Seek(-1, io.SeekStart)
+		if err == nil {
+			t.Fatal("expected seeking to a negative offset to fail")
+		}
+
+		_, err = s.Seek(-(size + 1), io.SeekEnd)
+		if err == nil {
+			t.Fatal("expected seeking before the start to fail")
+		}
+	})
+
+	t.Run("PastEnd", func(t *testing.T) {
+		s := newSeeker()
+		_, err := s.Seek(size+16, io.SeekStart)
+		if err != nil {
+			t.Skipf("seeking past the end isn't supported: %v", err)
+		}
+
+		pos, err := s.Seek(0, io.SeekStart)
+		if err != nil {
+			t.Fatalf("%v", err)
+		} else if pos != 0 {
+			t.Fatalf("expected offset 0, have %d", pos)
+		}
+	})
+}
+
+// TestReaderAt checks that the io.ReaderAt returned by newReaderAt
+// obeys the io.ReaderAt contract for data containing expected,
+// including when it is called concurrently.
+func TestReaderAt(t *testing.T, newReaderAt func() io.ReaderAt, expected []byte) {
+	size := int64(len(expected))
+
+	t.Run("Offsets", func(t *testing.T) {
+		r := newReaderAt()
+		for off := int64(0); off < size; off += 1 + size/16 {
+			p := make([]byte, size-off+1)
+			n, err := r.ReadAt(p, off)
+			if n != len(p)-1 {
+				t.Fatalf("offset %d: expected %d bytes, have %d",
+					off, len(p)-1, n)
+			} else if err == nil {
+				t.Fatalf("offset %d: short ReadAt returned a nil error", off)
+			}
+
+			if !bytes.Equal(p[:n], expected[off:]) {
+				t.Fatalf("offset %d: expected %x, have %x",
+					off, expected[off:], p[:n])
+			}
+		}
+	})
+
+	t.Run("Exact", func(t *testing.T) {
+		p := make([]byte, size)
+		n, err := newReaderAt().ReadAt(p, 0)
+		if err != nil && err != io.EOF {
+			t.Fatalf("%v", err)
+		} else if int64(n) != size {
+			t.Fatalf("expected %d bytes, have %d", size, n)
+		}
+	})
+
+	t.Run("AtEnd", func(t *testing.T) {
+		n, err := newReaderAt().ReadAt(make([]byte, 1), size)
+		if n != 0 || err == nil {
+			t.Fatalf("expected (0, error) at the end, have (%d, %v)", n, err)
+		}
+	})
+
+	t.Run("Negative", func(t *testing.T) {
+		_, err := newReaderAt().ReadAt(make([]byte, 1), -1)
+		if err == nil {
+			t.Fatal("expected reading at a negative offset to fail")
+		}
+	})
+
+	t.Run("Concurrent", func(t *testing.T) {
+		if size == 0 {
+			t.Skip("no data to read")
+		}
+
+		r := newReaderAt()
+		wg := &sync.WaitGroup{}
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func(seed int64) {
+				defer wg.Done()
+				prng := rand.New(rand.NewSource(seed))
+				for j := 0; j < 100; j++ {
+					off := prng.Int63n(size)
+					p := make([]byte, 1+prng.Int63n(size-off))
+					n, err := r.ReadAt(p, off)
+					if n != len(p) {
+						t.Errorf("offset %d: expected %d bytes, have %d (%v)",
+							off, len(p), n, err)
+						return
+					}
+
+					if !bytes.Equal(p, expected[off:off+int64(n)]) {
+						t.Errorf("offset %d: concurrent ReadAt returned the wrong data", off)
+						return
+					}
+				}
+			}(int64(i))
+		}
+		wg.Wait()
+	})
+}
@@ -0,0 +1,60 @@
+package testio
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestConformance(t *testing.T) {
+	data := []byte("the quick brown fox jumps over the lazy dog")
+
+	t.Run("BytesReader", func(t *testing.T) {
+		TestReader(t, func() io.Reader {
+			return bytes.NewReader(data)
+		}, data)
+	})
+
+	t.Run("BufCloser", func(t *testing.T) {
+		TestReader(t, func() io.Reader {
+			return NewBufCloser(append([]byte(nil), data...))
+		}, data)
+
+		TestWriter(t, func() (io.Writer, func() []byte) {
+			buf := NewBufCloser(nil)
+			return buf, buf.Bytes
+		})
+	})
+
+	t.Run("ChunkedReader", func(t *testing.T) {
+		TestReader(t, func() io.Reader {
+			return NewChunkedReader(bytes.NewReader(data), 5)
+		}, data)
+	})
+
+	t.Run("ChunkedWriter", func(t *testing.T) {
+		TestWriter(t, func() (io.Writer, func() []byte) {
+			buf := &bytes.Buffer{}
+			return NewChunkedWriter(buf, 5), buf.Bytes
+		})
+	})
+
+	t.Run("BytesBuffer", func(t *testing.T) {
+		TestWriter(t, func() (io.Writer, func() []byte) {
+			buf := &bytes.Buffer{}
+			return buf, buf.Bytes
+		})
+	})
+
+	t.Run("Seeker", func(t *testing.T) {
+		TestSeeker(t, func() io.ReadSeeker {
+			return bytes.NewReader(data)
+		}, data)
+	})
+
+	t.Run("ReaderAt", func(t *testing.T) {
+		TestReaderAt(t, func() io.ReaderAt {
+			return bytes.NewReader(data)
+		}, data)
+	})
+}
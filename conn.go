@@ -0,0 +1,323 @@
+package testio
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// A ConnFault is a failure that can be injected into a BufferConn.
+type ConnFault int
+
+const (
+	// FaultNone clears any fault.
+	FaultNone ConnFault = iota
+
+	// FaultReset makes reads and writes fail with ECONNRESET, as
+	// if the peer had reset the connection.
+	FaultReset
+
+	// FaultBrokenPipe makes writes fail with EPIPE and reads
+	// return io.EOF, as if the peer had gone away.
+	FaultBrokenPipe
+
+	// FaultStall makes reads and writes block until a deadline
+	// passes, the fault is cleared, or the connection is closed.
+	FaultStall
+
+	// FaultDrop silently drops data: writes succeed but nothing
+	// is delivered, and nothing more is received.
+	FaultDrop
+)
+
+// BufferConn is a type that can be used to simulate network
+// connections between a "client" (the code that uses the BufferConn)
+// and some simulated "peer". Writes go to a "client" buffer, which is
+// used to record the data sent by the caller, which may be read with
+// ReadClient. The peer's responses may be simulated by calling
+// WritePeer; when the client reads from the BufferConn, they will see
+// this data.
+//
+// A BufferConn implements net.Conn, including deadlines, and is safe
+// for concurrent use. Faults such as connection resets may be
+// injected with InjectFault, FaultAfterWrite and FaultAfterRead; these
+// can be called from another goroutine in the middle of a
+// conversation, and affect only this end of the connection.
+type BufferConn struct {
+	client, peer *pipe
+	laddr, raddr Addr
+
+	// other is the peer's view of the connection.
+	other *BufferConn
+
+	// timeout is the Expect timeout, stored as a time.Duration.
+	timeout atomic.Int64
+
+	// fmu serialises changes to the fault. stopHang stops watching
+	// the context of the last HangContext, and hangs counts the
+	// changes, so that a watch that has already fired can tell it
+	// is stale.
+	fmu      sync.Mutex
+	stopHang func() bool
+	hangs    int
+
+	stats statsRecorder
+}
+
+// NewBufferConn initialises a new simulated network connection. Reads
+// from the connection (and ReadClient) don't block: if no data is
+// pending, they return io.EOF.
+func NewBufferConn() *BufferConn {
+	return newBufferConn(false, "client", "peer")
+}
+
+// NewBufferConnPair initialises a pair of connected BufferConns that
+// behave like the two ends of a network connection: data written to
+// one may be read from the other, and reads block until data arrives,
+// the other end is closed, or a deadline passes. The second conn is
+// the first's Peer.
+func NewBufferConnPair() (*BufferConn, *BufferConn) {
+	bc := newBufferConn(true, "client", "peer")
+	return bc, bc.Peer()
+}
+
+func newBufferConn(block bool, laddr, raddr Addr) *BufferConn {
+	bc := &BufferConn{
+		client: newPipe(block),
+		peer:   newPipe(block),
+		laddr:  laddr,
+		raddr:  raddr,
+	}
+
+	bc.other = &BufferConn{
+		client: bc.peer,
+		peer:   bc.client,
+		laddr:  bc.raddr,
+		raddr:  bc.laddr,
+		other:  bc,
+	}
+	return bc
+}
+
+// Peer returns the peer's view of the connection: a BufferConn that
+// shares bc's buffers, so that writing to it is the same as calling
+// WritePeer, and reading from it is the same as calling
+// ReadClient. This allows an existing server implementation or a
+// bufio.Reader to act as the simulated peer. The Peer of the returned
+// conn is bc.
+//
+// Like bc, the peer's reads only block if bc was created with
+// NewBufferConnPair; otherwise, they return io.EOF when the client
+// hasn't written anything.
+func (bc *BufferConn) Peer() *BufferConn {
+	return bc.other
+}
+
+// opError wraps errors from the underlying pipes in a *net.OpError,
+// as a real network connection would.
+func (bc *BufferConn) opError(op string, err error) error {
+	if err == nil || err == io.EOF {
+		return err
+	}
+
+	if errno, ok := err.(syscall.Errno); ok {
+		err = os.NewSyscallError(op, errno)
+	}
+
+	return &net.OpError{
+		Op:     op,
+		Net:    bc.laddr.Network(),
+		Source: bc.laddr,
+		Addr:   bc.raddr,
+		Err:    err,
+	}
+}
+
+// Write writes to the client buffer.
+func (bc *BufferConn) Write(p []byte) (int, error) {
+	n, err := bc.client.write(p)
+	err = bc.opError("write", err)
+	bc.stats.write(len(p), n, err)
+	return n, err
+}
+
+// Read reads from the peer buffer.
+func (bc *BufferConn) Read(p []byte) (int, error) {
+	n, err := bc.peer.read(p)
+	err = bc.opError("read", err)
+	bc.stats.read(len(p), n, err)
+	return n, err
+}
+
+// WritePeer writes data to the peer buffer.
+func (bc *BufferConn) WritePeer(p []byte) (int, error) {
+	return bc.other.Write(p)
+}
+
+// ReadClient reads data from the client buffer.
+func (bc *BufferConn) ReadClient(p []byte) (int, error) {
+	return bc.other.Read(p)
+}
+
+// Close closes the connection. Any blocked reads are unblocked, and
+// the peer will see io.EOF once it has read the data already
+// written. Further reads and writes will fail.
+func (bc *BufferConn) Close() error {
+	wclosed := bc.client.closeWrite()
+	rclosed := bc.peer.closeRead()
+	if !wclosed && !rclosed {
+		return bc.opError("close", net.ErrClosed)
+	}
+	return nil
+}
+
+// CloseWrite shuts down the writing side of the connection, like
+// (*net.TCPConn).CloseWrite. Once the peer has read the data already
+// written, it sees io.EOF; further writes fail with EPIPE.
+func (bc *BufferConn) CloseWrite() error {
+	return bc.opError("close", bc.client.shutWrite())
+}
+
+// CloseRead shuts down the reading side of the connection, like
+// (*net.TCPConn).CloseRead. Unread data is discarded, reads return
+// io.EOF, and anything the peer writes afterwards is dropped.
+func (bc *BufferConn) CloseRead() error {
+	return bc.opError("close", bc.peer.shutRead())
+}
+
+// CloseWritePeer shuts down the peer's writing side of the
+// connection. Once the client has read the data already written with
+// WritePeer, its reads return io.EOF.
+func (bc *BufferConn) CloseWritePeer() error {
+	return bc.opError("close", bc.peer.shutWrite())
+}
+
+// CloseReadClient shuts down the peer's reading side of the
+// connection. Data the client has written but the peer hasn't read is
+// discarded, and further writes by the client are dropped.
+func (bc *BufferConn) CloseReadClient() error {
+	return bc.opError("close", bc.client.shutRead())
+}
+
+// LocalAddr returns the local address of the connection.
+func (bc *BufferConn) LocalAddr() net.Addr {
+	return bc.laddr
+}
+
+// RemoteAddr returns the address of the peer.
+func (bc *BufferConn) RemoteAddr() net.Addr {
+	return bc.raddr
+}
+
+// SetDeadline sets the read and write deadlines for the connection.
+func (bc *BufferConn) SetDeadline(t time.Time) error {
+	bc.peer.setReadDeadline(t)
+	bc.client.setWriteDeadline(t)
+	return nil
+}
+
+// SetReadDeadline sets the deadline for reads from the connection. A
+// zero value for t means reads will not time out.
+func (bc *BufferConn) SetReadDeadline(t time.Time) error {
+	bc.peer.setReadDeadline(t)
+	return nil
+}
+
+// SetWriteDeadline sets the deadline for writes to the
+// connection. Writes never block, so this only has an effect once the
+// deadline has passed.
+func (bc *BufferConn) SetWriteDeadline(t time.Time) error {
+	bc.client.setWriteDeadline(t)
+	return nil
+}
+
+// InjectFault makes f take effect immediately on both reads and
+// writes, waking any blocked calls. Injecting FaultNone clears any
+// current or scheduled fault.
+func (bc *BufferConn) InjectFault(f ConnFault) {
+	bc.fmu.Lock()
+	defer bc.fmu.Unlock()
+
+	bc.injectFault(f)
+}
+
+// injectFault sets the fault, ending any HangContext. The caller must
+// hold bc.fmu.
+func (bc *BufferConn) injectFault(f ConnFault) {
+	if bc.stopHang != nil {
+		bc.stopHang()
+		bc.stopHang = nil
+	}
+	bc.hangs++
+
+	bc.peer.setReadFault(f, 0)
+	bc.client.setWriteFault(f, 0)
+}
+
+// FaultAfterWrite schedules f to take effect on writes once another n
+// bytes have been written to the connection. A write that crosses the
+// limit writes the bytes before it, then fails.
+func (bc *BufferConn) FaultAfterWrite(n int, f ConnFault) {
+	bc.client.setWriteFault(f, n)
+}
+
+// FaultAfterRead schedules f to take effect on reads once another n
+// bytes have been read from the connection.
+func (bc *BufferConn) FaultAfterRead(n int, f ConnFault) {
+	bc.peer.setReadFault(f, n)
+}
+
+// Hang makes reads from and writes to the connection block until
+// Release is called, the connection is closed, or a deadline passes,
+// so that watchdogs and idle timeouts can be tested. It is the same
+// as injecting FaultStall.
+func (bc *BufferConn) Hang() {
+	bc.InjectFault(FaultStall)
+}
+
+// HangContext is like Hang, but blocked reads and writes also fail
+// with ctx.Err() once ctx is done. The context stops mattering once
+// the hang ends, or another fault is injected.
+func (bc *BufferConn) HangContext(ctx context.Context) {
+	bc.fmu.Lock()
+	defer bc.fmu.Unlock()
+
+	bc.injectFault(FaultStall)
+	hang := bc.hangs
+	bc.stopHang = context.AfterFunc(ctx, func() {
+		bc.fmu.Lock()
+		defer bc.fmu.Unlock()
+
+		if bc.hangs != hang {
+			return
+		}
+		bc.peer.endReadStall(ctx.Err())
+		bc.client.endWriteStall(ctx.Err())
+	})
+}
+
+// Release ends a Hang, allowing blocked reads and writes to continue.
+// It is the same as injecting FaultNone.
+func (bc *BufferConn) Release() {
+	bc.InjectFault(FaultNone)
+}
+
+// Blocked returns the number of calls to Read and Write currently
+// blocked by a Hang or a FaultStall.
+func (bc *BufferConn) Blocked() int {
+	readers, _ := bc.peer.stalled()
+	_, writers := bc.client.stalled()
+	return readers + writers
+}
+
+// Stats returns counts of the reads and writes made on this end of
+// the connection. Calls to WritePeer and ReadClient are counted by
+// the Peer.
+func (bc *BufferConn) Stats() Stats {
+	return bc.stats.stats()
+}
@@ -0,0 +1,330 @@
+package testio
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// MakePipe creates a pair of connected net.Conns for TestConn. Data
+// written to c1 should be readable from c2, and vice versa. The stop
+// function is called when a check has finished with the pair, and
+// should release any resources held by them.
+type MakePipe func() (c1, c2 net.Conn, stop func(), err error)
+
+// connTimeout bounds how long any single connection check may take.
+const connTimeout = 10 * time.Second
+
+// TestConn checks that the connections returned by mp behave like a
+// real network connection: data is transferred intact in both
+// directions, deadlines time out, closing a connection unblocks
+// pending reads, and concurrent use is safe. If the connections
+// implement CloseWrite, half-closes are checked too.
+func TestConn(t *testing.T, mp MakePipe) {
+	checks := []struct {
+		name string
+		fn   func(*testing.T, net.Conn, net.Conn)
+	}{
+		{"LargeTransfer", connLargeTransfer},
+		{"PingPong", connPingPong},
+		{"Concurrent", connConcurrent},
+		{"PastDeadline", connPastDeadline},
+		{"FutureDeadline", connFutureDeadline},
+		{"CloseUnblocksRead", connCloseUnblocksRead},
+		{"PeerClose", connPeerClose},
+		{"HalfClose", connHalfClose},
+		{"Addrs", connAddrs},
+	}
+
+	for _, check := range checks {
+		fn := check.fn
+		t.Run(check.name, func(t *testing.T) {
+			c1, c2, stop, err := mp()
+			if err != nil {
+				t.Fatalf("unable to make pipe: %v", err)
+			}
+			defer stop()
+
+			// Make sure a broken connection can't hang the test.
+			limit := time.Now().Add(connTimeout)
+			c1.SetDeadline(limit)
+			c2.SetDeadline(limit)
+			fn(t, c1, c2)
+		})
+	}
+}
+
+// connLargeTransfer writes a megabyte through c1 in odd-sized chunks
+// and checks that it all arrives at c2.
+func connLargeTransfer(t *testing.T, c1, c2 net.Conn) {
+	data := make([]byte, 1<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := NewChunkedWriter(c1, 4093).Write(data)
+		if err == nil {
+			err = c1.Close()
+		}
+		errs <- err
+	}()
+
+	received, err := io.ReadAll(c2)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if err = <-errs; err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if !bytes.Equal(received, data) {
+		t.Fatalf("received %d bytes, which don't match the %d sent",
+			len(received), len(data))
+	}
+}
+
+// connPingPong bounces a counter back and forth between c1 and c2.
+func connPingPong(t *testing.T, c1, c2 net.Conn) {
+	errs := make(chan error, 1)
+	go func() {
+		p := make([]byte, 1)
+		for {
+			_, err := io.ReadFull(c2, p)
+			if err == io.EOF {
+				errs <- nil
+				return
+			} else if err != nil {
+				errs <- err
+				return
+			}
+
+			p[0]++
+			if _, err = c2.Write(p); err != nil {
+				errs <- err
+				return
+			}
+		}
+	}()
+
+	p := []byte{0}
+	for i := 0; i < 100; i++ {
+		want := p[0] + 1
+		if _, err := c1.Write(p); err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		if _, err := io.ReadFull(c1, p); err != nil {
+			t.Fatalf("%v", err)
+		} else if p[0] != want {
+			t.Fatalf("expected %d, have %d", want, p[0])
+		}
+	}
+
+	c1.Close()
+	if err := <-errs; err != nil {
+		t.Fatalf("%v", err)
+	}
+}
+
+// connConcurrent writes from several goroutines on each side while
+// others read and poke at the connection's other methods.
+func connConcurrent(t *testing.T, c1, c2 net.Conn) {
+	const writers = 4
+	const perWriter = 64 << 10
+
+	wg := &sync.WaitGroup{}
+	transfer := func(w, r net.Conn) {
+		wwg := &sync.WaitGroup{}
+		for i := 0; i < writers; i++ {
+			wwg.Add(1)
+			go func() {
+				defer wwg.Done()
+				p := make([]byte, 1024)
+				for n := 0; n < perWriter; n += len(p) {
+					if _, err := w.Write(p); err != nil {
+						t.Errorf("%v", err)
+						return
+					}
+				}
+			}()
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var total int
+			p := make([]byte, 1500)
+			for total < writers*perWriter {
+				n, err := r.Read(p)
+				total += n
+				if err != nil {
+					t.Errorf("read failed after %d bytes: %v", total, err)
+					return
+				}
+			}
+		}()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				w.LocalAddr()
+				w.RemoteAddr()
+				w.SetWriteDeadline(time.Now().Add(connTimeout))
+			}
+		}()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			wwg.Wait()
+		}()
+	}
+
+	transfer(c1, c2)
+	transfer(c2, c1)
+	wg.Wait()
+}
+
+// isTimeout returns true if err is a timeout error as returned by a
+// net.Conn whose deadline has passed.
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout() &&
+		errors.Is(err, os.ErrDeadlineExceeded)
+}
+
+// connPastDeadline checks that deadlines in the past fail reads and
+// writes immediately, and that clearing them recovers the connection.
+func connPastDeadline(t *testing.T, c1, c2 net.Conn) {
+	c1.SetDeadline(time.Now().Add(-time.Second))
+
+	_, err := c1.Read(make([]byte, 1))
+	if !isTimeout(err) {
+		t.Fatalf("expected a read timeout, have %v", err)
+	}
+
+	_, err = c1.Write([]byte("A"))
+	if !isTimeout(err) {
+		t.Fatalf("expected a write timeout, have %v", err)
+	}
+
+	c1.SetDeadline(time.Time{})
+	go c2.Write([]byte("B"))
+
+	p := make([]byte, 1)
+	_, err = io.ReadFull(c1, p)
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if p[0] != 'B' {
+		t.Fatalf("expected to read 'B', have '%c'", p[0])
+	}
+}
+
+// connFutureDeadline checks that a blocked read times out once its
+// deadline passes.
+func connFutureDeadline(t *testing.T, c1, c2 net.Conn) {
+	const wait = 50 * time.Millisecond
+
+	start := time.Now()
+	c1.SetReadDeadline(start.Add(wait))
+	_, err := c1.Read(make([]byte, 1))
+	if !isTimeout(err) {
+		t.Fatalf("expected a read timeout, have %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed < wait {
+		t.Fatalf("read timed out after %s, before its deadline", elapsed)
+	}
+}
+
+// connCloseUnblocksRead checks that closing a connection unblocks a
+// pending read on it.
+func connCloseUnblocksRead(t *testing.T, c1, c2 net.Conn) {
+	errs := make(chan error, 1)
+	go func() {
+		_, err := c1.Read(make([]byte, 1))
+		errs <- err
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	if err := c1.Close(); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	select {
+	case err := <-errs:
+		if err == nil {
+			t.Fatal("expected a read on a closed connection to fail")
+		}
+	case <-time.After(connTimeout):
+		t.Fatal("closing the connection didn't unblock the read")
+	}
+}
+
+// connPeerClose checks that closing one end delivers pending data and
+// then io.EOF to the other.
+func connPeerClose(t *testing.T, c1, c2 net.Conn) {
+	go func() {
+		c2.Write([]byte("AB"))
+		c2.Close()
+	}()
+
+	data, err := io.ReadAll(c1)
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if string(data) != "AB" {
+		t.Fatalf("expected to read 'AB', have '%s'", data)
+	}
+}
+
+// connHalfClose checks that after c1 closes its write side, c2 sees
+// io.EOF but can still send data back.
+func connHalfClose(t *testing.T, c1, c2 net.Conn) {
+	cw, ok := c1.(interface {
+		CloseWrite() error
+	})
+	if !ok {
+		t.Skip("connection doesn't implement CloseWrite")
+	}
+
+	go func() {
+		c1.Write([]byte("AB"))
+		cw.CloseWrite()
+	}()
+
+	data, err := io.ReadAll(c2)
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if string(data) != "AB" {
+		t.Fatalf("expected to read 'AB', have '%s'", data)
+	}
+
+	go func() {
+		c2.Write([]byte("XY"))
+		c2.Close()
+	}()
+
+	data, err = io.ReadAll(c1)
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if string(data) != "XY" {
+		t.Fatalf("expected to read 'XY', have '%s'", data)
+	}
+}
+
+// connAddrs checks that both ends have addresses.
+func connAddrs(t *testing.T, c1, c2 net.Conn) {
+	for _, c := range []net.Conn{c1, c2} {
+		if c.LocalAddr() == nil || c.RemoteAddr() == nil {
+			t.Fatal("connection is missing an address")
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package testio
+
+import (
+	"net"
+	"testing"
+)
+
+func TestConnBufferConnPair(t *testing.T) {
+	TestConn(t, func() (net.Conn, net.Conn, func(), error) {
+		c1, c2 := NewBufferConnPair()
+		stop := func() {
+			c1.Close()
+			c2.Close()
+		}
+		return c1, c2, stop, nil
+	})
+}
+
+func TestConnPipe(t *testing.T) {
+	TestConn(t, func() (net.Conn, net.Conn, func(), error) {
+		c1, c2 := net.Pipe()
+		stop := func() {
+			c1.Close()
+			c2.Close()
+		}
+		return c1, c2, stop, nil
+	})
+}
+
+func TestConnTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("unable to listen on loopback: %v", err)
+	}
+	defer ln.Close()
+
+	TestConn(t, func() (net.Conn, net.Conn, func(), error) {
+		c1, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		c2, err := ln.Accept()
+		if err != nil {
+			c1.Close()
+			return nil, nil, nil, err
+		}
+
+		stop := func() {
+			c1.Close()
+			c2.Close()
+		}
+		return c1, c2, stop, nil
+	})
+}
@@ -0,0 +1,160 @@
+package testio
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+// ioResult is the outcome of a read or write made in the background.
+type ioResult struct {
+	n   int
+	err error
+}
+
+// ContextReader is an io.Reader that stops reading when a context is
+// done. Each read is made in a separate goroutine, so that a read
+// blocked in the underlying reader returns ctx.Err() as soon as the
+// context is cancelled. The abandoned read is left to finish in the
+// background, and its data is discarded; once the context is done,
+// every read fails.
+type ContextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+// NewContextReader creates a ContextReader that reads from r until ctx
+// is done.
+func NewContextReader(ctx context.Context, r io.Reader) *ContextReader {
+	return &ContextReader{ctx: ctx, r: r}
+}
+
+// Read reads from the underlying reader, returning ctx.Err() if the
+// context is done first.
+func (cr *ContextReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	// The read goes into a separate buffer, as p can't be touched
+	// after Read returns.
+	buf := make([]byte, len(p))
+	done := make(chan ioResult, 1)
+	go func() {
+		n, err := cr.r.Read(buf)
+		done <- ioResult{n, err}
+	}()
+
+	select {
+	case res := <-done:
+		return copy(p, buf[:res.n]), res.err
+	case <-cr.ctx.Done():
+		return 0, cr.ctx.Err()
+	}
+}
+
+// ContextWriter is an io.Writer that stops writing when a context is
+// done. Like a ContextReader, it makes each write in a separate
+// goroutine, so a blocked write returns ctx.Err() as soon as the
+// context is cancelled; the abandoned write may still complete in the
+// background.
+type ContextWriter struct {
+	ctx context.Context
+	w   io.Writer
+}
+
+// NewContextWriter creates a ContextWriter that writes to w until ctx
+// is done.
+func NewContextWriter(ctx context.Context, w io.Writer) *ContextWriter {
+	return &ContextWriter{ctx: ctx, w: w}
+}
+
+// Write writes to the underlying writer, returning ctx.Err() if the
+// context is done first.
+func (cw *ContextWriter) Write(p []byte) (int, error) {
+	if err := cw.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	buf := append([]byte(nil), p...)
+	done := make(chan ioResult, 1)
+	go func() {
+		n, err := cw.w.Write(buf)
+		done <- ioResult{n, err}
+	}()
+
+	select {
+	case res := <-done:
+		return res.n, res.err
+	case <-cw.ctx.Done():
+		return 0, cw.ctx.Err()
+	}
+}
+
+// hangingReadWriter is an io.ReadWriter whose reads and writes hang.
+type hangingReadWriter struct {
+	*HangingReader
+	*HangingWriter
+}
+
+func newHangingReadWriter() *hangingReadWriter {
+	return &hangingReadWriter{
+		HangingReader: NewHangingReader(nil),
+		HangingWriter: NewHangingWriter(0),
+	}
+}
+
+func (rw *hangingReadWriter) blocked() int {
+	return rw.HangingReader.Blocked() + rw.HangingWriter.Blocked()
+}
+
+func (rw *hangingReadWriter) close() {
+	rw.HangingReader.Close()
+	rw.HangingWriter.Close()
+}
+
+// AssertCancels checks that fn honours context cancellation. It calls
+// fn with a context and an io.ReadWriter whose reads and writes block
+// indefinitely, waits for fn to block on it, then cancels the
+// context. The test fails if fn doesn't block within timeout, doesn't
+// return within timeout of the cancellation, or returns an error that
+// isn't context.Canceled.
+func AssertCancels(t testing.TB, timeout time.Duration, fn func(ctx context.Context, rw io.ReadWriter) error) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rw := newHangingReadWriter()
+	defer rw.close()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- fn(ctx, rw)
+	}()
+
+	ticker := time.NewTicker(time.Millisecond)
+	defer ticker.Stop()
+	expired := time.After(timeout)
+	for rw.blocked() == 0 {
+		select {
+		case <-ticker.C:
+		case err := <-done:
+			t.Fatalf("returned before blocking on I/O: %v", err)
+		case <-expired:
+			t.Fatalf("didn't block on I/O within %v", timeout)
+		}
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected a context.Canceled error, have %v", err)
+		}
+	case <-time.After(timeout):
+		t.Fatalf("didn't return within %v of the context being cancelled", timeout)
+	}
+}
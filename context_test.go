@@ -0,0 +1,103 @@
+package testio
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestContextReader(t *testing.T) {
+	client, peer := NewBufferConnPair()
+	defer client.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cr := NewContextReader(ctx, client)
+
+	peer.Write([]byte("AB"))
+	buf := make([]byte, 4)
+	n, err := cr.Read(buf)
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if string(buf[:n]) != "AB" {
+		t.Fatalf("expected 'AB', have '%s'", buf[:n])
+	}
+
+	time.AfterFunc(10*time.Millisecond, cancel)
+	if _, err = cr.Read(buf); err != context.Canceled {
+		t.Fatalf("expected context.Canceled, have %v", err)
+	}
+
+	peer.Write([]byte("CD"))
+	if _, err = cr.Read(buf); err != context.Canceled {
+		t.Fatalf("expected context.Canceled, have %v", err)
+	}
+}
+
+func TestContextWriter(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	buf := &bytes.Buffer{}
+	cw := NewContextWriter(ctx, buf)
+
+	if _, err := cw.Write([]byte("AB")); err != nil {
+		t.Fatalf("%v", err)
+	} else if buf.String() != "AB" {
+		t.Fatalf("expected 'AB', have '%s'", buf.String())
+	}
+
+	hw := NewHangingWriter(0)
+	defer hw.Close()
+	cw = NewContextWriter(ctx, hw)
+
+	time.AfterFunc(10*time.Millisecond, cancel)
+	if _, err := cw.Write([]byte("CD")); err != context.Canceled {
+		t.Fatalf("expected context.Canceled, have %v", err)
+	}
+}
+
+func TestAssertCancels(t *testing.T) {
+	AssertCancels(t, time.Second, func(ctx context.Context, rw io.ReadWriter) error {
+		_, err := io.ReadAll(NewContextReader(ctx, rw))
+		return err
+	})
+
+	AssertCancels(t, time.Second, func(ctx context.Context, rw io.ReadWriter) error {
+		_, err := NewContextWriter(ctx, rw).Write([]byte("A"))
+		return err
+	})
+
+	failure := expectFailure(t, func(tb testing.TB) {
+		AssertCancels(tb, 50*time.Millisecond, func(ctx context.Context, rw io.ReadWriter) error {
+			_, err := io.ReadAll(rw)
+			return err
+		})
+	})
+	if !strings.Contains(failure, "didn't return") {
+		t.Fatalf("expected a failure to return, have '%s'", failure)
+	}
+
+	failure = expectFailure(t, func(tb testing.TB) {
+		AssertCancels(tb, 50*time.Millisecond, func(ctx context.Context, rw io.ReadWriter) error {
+			return errors.New("no I/O")
+		})
+	})
+	if !strings.Contains(failure, "before blocking") {
+		t.Fatalf("expected a failure to block, have '%s'", failure)
+	}
+
+	failure = expectFailure(t, func(tb testing.TB) {
+		AssertCancels(tb, 50*time.Millisecond, func(ctx context.Context, rw io.ReadWriter) error {
+			_, err := NewContextReader(ctx, rw).Read(make([]byte, 1))
+			if err != nil {
+				return errors.New("read failed")
+			}
+			return nil
+		})
+	})
+	if !strings.Contains(failure, "context.Canceled") {
+		t.Fatalf("expected a wrong error, have '%s'", failure)
+	}
+}
@@ -0,0 +1,302 @@
+package testio
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"sync"
+)
+
+// A CorruptionKind is a way in which a Corrupter damages data.
+type CorruptionKind int
+
+const (
+	// CorruptFlip flips a single bit.
+	CorruptFlip CorruptionKind = iota
+
+	// CorruptZero replaces bytes with zeros.
+	CorruptZero
+
+	// CorruptDuplicate repeats a range of bytes.
+	CorruptDuplicate
+
+	// CorruptDrop removes a range of bytes.
+	CorruptDrop
+)
+
+var corruptionNames = map[CorruptionKind]string{
+	CorruptFlip:      "flip",
+	CorruptZero:      "zero",
+	CorruptDuplicate: "duplicate",
+	CorruptDrop:      "drop",
+}
+
+// String returns the name of the kind of corruption.
+func (k CorruptionKind) String() string {
+	if name, ok := corruptionNames[k]; ok {
+		return name
+	}
+	return fmt.Sprintf("CorruptionKind(%d)", int(k))
+}
+
+// A Corruption records a change made to the data by a Corrupter.
+type Corruption struct {
+	Kind CorruptionKind
+
+	// Offset is the offset, in the original stream, of the first
+	// byte affected.
+	Offset int64
+
+	// Len is the number of bytes affected.
+	Len int
+
+	// Bit is the bit that was flipped, for CorruptFlip.
+	Bit uint
+}
+
+// String describes the corruption.
+func (c Corruption) String() string {
+	if c.Kind == CorruptFlip {
+		return fmt.Sprintf("flip bit %d at offset %d", c.Bit, c.Offset)
+	}
+	return fmt.Sprintf("%s %d bytes at offset %d", c.Kind, c.Len, c.Offset)
+}
+
+// corruptRule is a corruption scheduled at a fixed offset.
+type corruptRule struct {
+	kind CorruptionKind
+	off  int64
+	n    int
+	bit  uint
+}
+
+func (r corruptRule) contains(off int64) bool {
+	return off >= r.off && off < r.off+int64(r.n)
+}
+
+// Corrupter damages data passing through its readers and writers,
+// either at fixed offsets or at random, and records every change it
+// makes so that tests can check that the corruption was detected.
+// Offsets are counted from the start of each reader or writer's
+// stream, before any corruption. It is safe for concurrent use, but
+// the record is only easy to interpret if each Corrupter is used for
+// a single stream.
+type Corrupter struct {
+	mu    sync.Mutex
+	rules []corruptRule
+	prng  *rand.Rand
+	rate  float64
+	kinds []CorruptionKind
+	log   []Corruption
+}
+
+// NewCorrupter creates a Corrupter whose random corruption is driven
+// by seed. Initially, it doesn't change anything.
+func NewCorrupter(seed int64) *Corrupter {
+	return &Corrupter{prng: rand.New(rand.NewSource(seed))}
+}
+
+func (c *Corrupter) addRule(r corruptRule) {
+	c.mu.Lock()
+	c.rules = append(c.rules, r)
+	c.mu.Unlock()
+}
+
+// FlipBit flips bit (0-7) of the byte at off.
+func (c *Corrupter) FlipBit(off int64, bit uint) {
+	c.addRule(corruptRule{kind: CorruptFlip, off: off, n: 1, bit: bit % 8})
+}
+
+// Zero replaces n bytes starting at off with zeros.
+func (c *Corrupter) Zero(off int64, n int) {
+	c.addRule(corruptRule{kind: CorruptZero, off: off, n: n})
+}
+
+// Duplicate repeats the n bytes starting at off.
+func (c *Corrupter) Duplicate(off int64, n int) {
+	c.addRule(corruptRule{kind: CorruptDuplicate, off: off, n: n})
+}
+
+// Drop removes the n bytes starting at off.
+func (c *Corrupter) Drop(off int64, n int) {
+	c.addRule(corruptRule{kind: CorruptDrop, off: off, n: n})
+}
+
+// SetRate corrupts each byte with probability rate, using one of the
+// given kinds of corruption chosen at random. If no kinds are given,
+// all of them are used.
+func (c *Corrupter) SetRate(rate float64, kinds ...CorruptionKind) {
+	if len(kinds) == 0 {
+		kinds = []CorruptionKind{CorruptFlip, CorruptZero, CorruptDuplicate, CorruptDrop}
+	}
+
+	c.mu.Lock()
+	c.rate = rate
+	c.kinds = kinds
+	c.mu.Unlock()
+}
+
+// Corruptions returns the changes made so far, in the order they were
+// made.
+func (c *Corrupter) Corruptions() []Corruption {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]Corruption(nil), c.log...)
+}
+
+// Reader returns a CorruptReader that corrupts the data read from r.
+func (c *Corrupter) Reader(r io.Reader) *CorruptReader {
+	return &CorruptReader{r: r, s: c.stream()}
+}
+
+// Writer returns a CorruptWriter that corrupts the data written to w.
+func (c *Corrupter) Writer(w io.Writer) *CorruptWriter {
+	return &CorruptWriter{w: w, s: c.stream()}
+}
+
+func (c *Corrupter) stream() *corruptStream {
+	return &corruptStream{c: c, dup: map[int][]byte{}}
+}
+
+// corruptStream tracks the position in a single stream passing
+// through a Corrupter.
+type corruptStream struct {
+	c   *Corrupter
+	off int64
+
+	// dup holds the bytes seen so far of each duplicated range,
+	// keyed by rule.
+	dup map[int][]byte
+}
+
+// transform returns the corrupted version of p, which is the next
+// part of the stream.
+func (s *corruptStream) transform(p []byte) []byte {
+	c := s.c
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	out := make([]byte, 0, len(p))
+	for _, b := range p {
+		off := s.off
+		s.off++
+
+		drop := false
+		var dups [][]byte
+		for i, r := range c.rules {
+			if !r.contains(off) {
+				continue
+			}
+
+			start := off == r.off
+			switch r.kind {
+			case CorruptFlip:
+				b ^= 1 << r.bit
+				c.log = append(c.log, Corruption{Kind: r.kind, Offset: off, Len: 1, Bit: r.bit})
+			case CorruptZero:
+				b = 0
+				if start {
+					c.log = append(c.log, Corruption{Kind: r.kind, Offset: off, Len: r.n})
+				}
+			case CorruptDrop:
+				drop = true
+				if start {
+					c.log = append(c.log, Corruption{Kind: r.kind, Offset: off, Len: r.n})
+				}
+			case CorruptDuplicate:
+				// The range is repeated once its last byte
+				// has been seen.
+				s.dup[i] = append(s.dup[i], b)
+				if off == r.off+int64(r.n)-1 {
+					dups = append(dups, s.dup[i])
+					delete(s.dup, i)
+					c.log = append(c.log, Corruption{Kind: r.kind, Offset: r.off, Len: r.n})
+				}
+			}
+		}
+
+		if c.rate > 0 && c.prng.Float64() < c.rate {
+			kind := c.kinds[c.prng.Intn(len(c.kinds))]
+			record := Corruption{Kind: kind, Offset: off, Len: 1}
+			switch kind {
+			case CorruptFlip:
+				record.Bit = uint(c.prng.Intn(8))
+				b ^= 1 << record.Bit
+			case CorruptZero:
+				b = 0
+			case CorruptDrop:
+				drop = true
+			case CorruptDuplicate:
+				dups = append(dups, []byte{b})
+			}
+			c.log = append(c.log, record)
+		}
+
+		if !drop {
+			out = append(out, b)
+		}
+		for _, d := range dups {
+			out = append(out, d...)
+		}
+	}
+	return out
+}
+
+// CorruptReader is an io.Reader that corrupts the data read from an
+// underlying reader according to its Corrupter. It is safe for
+// concurrent use.
+type CorruptReader struct {
+	mu      sync.Mutex
+	r       io.Reader
+	s       *corruptStream
+	pending []byte
+	err     error
+}
+
+// Read reads and corrupts data from the underlying reader.
+func (cr *CorruptReader) Read(p []byte) (int, error) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	for len(cr.pending) == 0 && cr.err == nil {
+		buf := make([]byte, len(p))
+		n, err := cr.r.Read(buf)
+		cr.pending = cr.s.transform(buf[:n])
+		cr.err = err
+	}
+
+	n := copy(p, cr.pending)
+	cr.pending = cr.pending[n:]
+	if len(cr.pending) > 0 {
+		return n, nil
+	}
+	return n, cr.err
+}
+
+// CorruptWriter is an io.Writer that corrupts data according to its
+// Corrupter before writing it to an underlying writer. It is safe for
+// concurrent use.
+type CorruptWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+	s  *corruptStream
+}
+
+// Write corrupts p and writes the result to the underlying writer.
+// As the corrupted data may be a different length, a successful write
+// reports len(p) bytes written, and a failed one reports none.
+func (cw *CorruptWriter) Write(p []byte) (int, error) {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+
+	_, err := cw.w.Write(cw.s.transform(p))
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,112 @@
+package testio
+
+import (
+	"bytes"
+	"hash/crc32"
+	"io"
+	"testing"
+)
+
+func TestCorrupterOffsets(t *testing.T) {
+	c := NewCorrupter(1)
+	c.FlipBit(0, 0)
+	c.Zero(2, 2)
+	c.Duplicate(5, 2)
+	c.Drop(8, 1)
+
+	r := c.Reader(NewChunkedReader(bytes.NewBufferString("ABCDEFGHIJ"), 3))
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	expected := "@B\x00\x00EFGFGHJ"
+	if string(data) != expected {
+		t.Fatalf("expected %q, have %q", expected, data)
+	}
+
+	expectedLog := []Corruption{
+		{Kind: CorruptFlip, Offset: 0, Len: 1, Bit: 0},
+		{Kind: CorruptZero, Offset: 2, Len: 2},
+		{Kind: CorruptDuplicate, Offset: 5, Len: 2},
+		{Kind: CorruptDrop, Offset: 8, Len: 1},
+	}
+	log := c.Corruptions()
+	if len(log) != len(expectedLog) {
+		t.Fatalf("expected %v, have %v", expectedLog, log)
+	}
+	for i := range log {
+		if log[i] != expectedLog[i] {
+			t.Fatalf("expected %v, have %v", expectedLog[i], log[i])
+		}
+	}
+}
+
+func TestCorrupterWriter(t *testing.T) {
+	c := NewCorrupter(1)
+	c.Drop(1, 2)
+
+	buf := &bytes.Buffer{}
+	w := c.Writer(buf)
+	for _, s := range []string{"AB", "CD"} {
+		n, err := w.Write([]byte(s))
+		if err != nil {
+			t.Fatalf("%v", err)
+		} else if n != 2 {
+			t.Fatalf("expected to write 2 bytes, wrote %d", n)
+		}
+	}
+
+	if buf.String() != "AD" {
+		t.Fatalf("expected 'AD', have '%s'", buf.String())
+	}
+
+	w = NewCorrupter(1).Writer(NewBrokenWriter(0))
+	if n, err := w.Write([]byte("A")); err == nil {
+		t.Fatal("write to a broken writer should fail")
+	} else if n != 0 {
+		t.Fatalf("expected to write 0 bytes, wrote %d", n)
+	}
+}
+
+func TestCorrupterRate(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 100)
+	sum := crc32.ChecksumIEEE(data)
+
+	run := func() ([]byte, []Corruption) {
+		c := NewCorrupter(42)
+		c.SetRate(0.01, CorruptFlip)
+		out, err := io.ReadAll(c.Reader(bytes.NewReader(data)))
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		return out, c.Corruptions()
+	}
+
+	out, log := run()
+	if len(log) == 0 {
+		t.Fatal("expected some corruption")
+	} else if len(out) != len(data) {
+		t.Fatalf("flips shouldn't change the length: expected %d bytes, have %d",
+			len(data), len(out))
+	} else if crc32.ChecksumIEEE(out) == sum {
+		t.Fatal("the checksum should have detected the corruption")
+	}
+
+	// Undoing the recorded flips should restore the original data.
+	for _, c := range log {
+		if c.Kind != CorruptFlip {
+			t.Fatalf("expected only flips, have %v", c)
+		}
+		out[c.Offset] ^= 1 << c.Bit
+	}
+	if !bytes.Equal(out, data) {
+		t.Fatal("the log doesn't account for all of the corruption")
+	}
+
+	again, _ := run()
+	again2, log2 := run()
+	if !bytes.Equal(again, again2) || len(log2) != len(log) {
+		t.Fatal("the same seed should give the same corruption")
+	}
+}
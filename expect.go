@@ -0,0 +1,175 @@
+package testio
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// DefaultExpectTimeout is how long the Expect methods on a BufferConn
+// wait for the client to send enough data, unless SetExpectTimeout
+// has been called.
+const DefaultExpectTimeout = time.Second
+
+// SetExpectTimeout sets how long the Expect methods wait for data
+// from the client.
+func (bc *BufferConn) SetExpectTimeout(timeout time.Duration) {
+	bc.timeout.Store(int64(timeout))
+}
+
+func (bc *BufferConn) expectTimeout() time.Duration {
+	if timeout := time.Duration(bc.timeout.Load()); timeout > 0 {
+		return timeout
+	}
+	return DefaultExpectTimeout
+}
+
+// mismatch describes the difference between the data a test wanted
+// and the data it has.
+func mismatch(want, have []byte) string {
+	i := 0
+	for i < len(want) && i < len(have) && want[i] == have[i] {
+		i++
+	}
+
+	return fmt.Sprintf("differs at byte %d:\n\twant: %q\n\thave: %q",
+		i, want, have)
+}
+
+// ExpectClientBytes waits for the client to send len(want) bytes,
+// consumes them, and fails the test if they aren't want. Like the
+// other Expect methods, it must be called from the goroutine running
+// the test.
+func (bc *BufferConn) ExpectClientBytes(t testing.TB, want []byte) {
+	t.Helper()
+
+	data, ok := bc.client.expect(bc.expectTimeout(), func(data []byte) int {
+		if len(data) < len(want) {
+			return -1
+		}
+		return len(want)
+	})
+
+	if !ok {
+		t.Fatalf("timed out waiting for %d bytes from the client:\n\twant: %q\n\thave: %q",
+			len(want), want, data)
+	}
+
+	if have := data[:len(want)]; !bytes.Equal(have, want) {
+		t.Fatalf("client data %s", mismatch(want, have))
+	}
+}
+
+// ExpectClientLine waits for the client to send a line ending in
+// "\n", consumes it, and fails the test if it isn't want. The line
+// ending, which may be "\n" or "\r\n", isn't included in the
+// comparison.
+func (bc *BufferConn) ExpectClientLine(t testing.TB, want string) {
+	t.Helper()
+
+	data, ok := bc.client.expect(bc.expectTimeout(), func(data []byte) int {
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			return i + 1
+		}
+		return -1
+	})
+
+	if !ok {
+		t.Fatalf("timed out waiting for a line from the client:\n\twant: %q\n\thave: %q",
+			want, data)
+	}
+
+	line := data[:bytes.IndexByte(data, '\n')+1]
+	have := bytes.TrimSuffix(bytes.TrimSuffix(line, []byte("\n")), []byte("\r"))
+	if string(have) != want {
+		t.Fatalf("client data %s", mismatch([]byte(want), have))
+	}
+}
+
+// ExpectClientPrefix waits for the client to send at least
+// len(prefix) bytes, and fails the test if they don't start with
+// prefix. Unlike the other Expect methods, it doesn't consume any
+// data.
+func (bc *BufferConn) ExpectClientPrefix(t testing.TB, prefix []byte) {
+	t.Helper()
+
+	data, ok := bc.client.expect(bc.expectTimeout(), func(data []byte) int {
+		if len(data) < len(prefix) {
+			return -1
+		}
+		return 0
+	})
+
+	if !ok {
+		t.Fatalf("timed out waiting for %d bytes from the client:\n\twant: %q\n\thave: %q",
+			len(prefix), prefix, data)
+	}
+
+	if !bytes.HasPrefix(data, prefix) {
+		t.Fatalf("client data %s", mismatch(prefix, data[:len(prefix)]))
+	}
+}
+
+// ExpectClientJSON waits for the client to send a complete JSON
+// value, consumes it, and fails the test if it isn't equivalent to
+// want. The comparison is made after converting want to JSON and
+// back, so want may be any value that can be marshalled, such as a
+// struct or a map.
+func (bc *BufferConn) ExpectClientJSON(t testing.TB, want interface{}) {
+	t.Helper()
+
+	wantJSON, err := json.Marshal(want)
+	if err != nil {
+		t.Fatalf("unable to marshal the expected value: %v", err)
+	}
+
+	var wantValue, haveValue interface{}
+	json.Unmarshal(wantJSON, &wantValue)
+
+	var decodeErr error
+	data, ok := bc.client.expect(bc.expectTimeout(), func(data []byte) int {
+		haveValue = nil
+		dec := json.NewDecoder(bytes.NewReader(data))
+		decodeErr = dec.Decode(&haveValue)
+		switch decodeErr {
+		case nil:
+			return int(dec.InputOffset())
+		case io.EOF, io.ErrUnexpectedEOF:
+			return -1
+		}
+
+		// The data isn't valid JSON, so waiting won't help.
+		return 0
+	})
+
+	if !ok {
+		t.Fatalf("timed out waiting for JSON from the client:\n\twant: %s\n\thave: %q",
+			wantJSON, data)
+	} else if decodeErr != nil {
+		t.Fatalf("client sent invalid JSON (%v): %q", decodeErr, data)
+	}
+
+	if !reflect.DeepEqual(haveValue, wantValue) {
+		haveJSON, _ := json.Marshal(haveValue)
+		t.Fatalf("client sent different JSON:\n\twant: %s\n\thave: %s",
+			wantJSON, haveJSON)
+	}
+}
+
+// AssertNoMoreClientData fails the test if the client has sent data
+// that hasn't been read.
+func (bc *BufferConn) AssertNoMoreClientData(t testing.TB) {
+	t.Helper()
+
+	data, _ := bc.client.expect(0, func(data []byte) int {
+		return 0
+	})
+
+	if len(data) > 0 {
+		t.Fatalf("expected no more data from the client, have %q", data)
+	}
+}
@@ -0,0 +1,112 @@
+package testio
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeTB records failures from assertions and mocks instead of
+// failing the test.
+type fakeTB struct {
+	testing.TB
+
+	mu       sync.Mutex
+	failures []string
+	cleanups []func()
+}
+
+func (tb *fakeTB) Helper() {}
+
+func (tb *fakeTB) Errorf(format string, args ...interface{}) {
+	tb.mu.Lock()
+	tb.failures = append(tb.failures, fmt.Sprintf(format, args...))
+	tb.mu.Unlock()
+}
+
+func (tb *fakeTB) Fatalf(format string, args ...interface{}) {
+	tb.Errorf(format, args...)
+	runtime.Goexit()
+}
+
+func (tb *fakeTB) Cleanup(f func()) {
+	tb.cleanups = append(tb.cleanups, f)
+}
+
+// failure returns the most recent failure.
+func (tb *fakeTB) failure() string {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	if len(tb.failures) == 0 {
+		return ""
+	}
+	return tb.failures[len(tb.failures)-1]
+}
+
+// cleanup runs the registered cleanup functions.
+func (tb *fakeTB) cleanup() {
+	for _, f := range tb.cleanups {
+		f()
+	}
+}
+
+// expectFailure runs fn against a fakeTB, returning its failure.
+func expectFailure(t *testing.T, fn func(testing.TB)) string {
+	tb := &fakeTB{TB: t}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fn(tb)
+	}()
+	<-done
+	return tb.failure()
+}
+
+func TestExpectClient(t *testing.T) {
+	bc, peer := NewBufferConnPair()
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		bc.Write([]byte("HELO example.com\r\n"))
+		bc.Write([]byte(`{"id": 1, "tags": ["a", "b"]}`))
+		bc.Write([]byte("QUIT"))
+	}()
+
+	bc.ExpectClientLine(t, "HELO example.com")
+	bc.ExpectClientJSON(t, map[string]interface{}{
+		"id":   1,
+		"tags": []string{"a", "b"},
+	})
+	bc.ExpectClientPrefix(t, []byte("QU"))
+	bc.ExpectClientBytes(t, []byte("QUIT"))
+	bc.AssertNoMoreClientData(t)
+
+	bc.SetExpectTimeout(10 * time.Millisecond)
+	bc.Write([]byte("HELO example.org"))
+	failure := expectFailure(t, func(tb testing.TB) {
+		bc.ExpectClientBytes(tb, []byte("HELO example.com"))
+	})
+	if !strings.Contains(failure, "differs at byte 13") {
+		t.Fatalf("expected a mismatch at byte 13, have %s", failure)
+	}
+
+	failure = expectFailure(t, func(tb testing.TB) {
+		bc.ExpectClientLine(tb, "QUIT")
+	})
+	if !strings.Contains(failure, "timed out") {
+		t.Fatalf("expected a timeout, have %s", failure)
+	}
+
+	bc.Write([]byte("left over"))
+	failure = expectFailure(t, func(tb testing.TB) {
+		bc.AssertNoMoreClientData(tb)
+	})
+	if !strings.Contains(failure, "left over") {
+		t.Fatalf("expected the left over data to be reported, have %s", failure)
+	}
+
+	peer.Close()
+}
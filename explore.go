@@ -0,0 +1,130 @@
+package testio
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+// A WriteOutcome is the result of one run of the function being
+// explored by ExploreWriteFailures.
+type WriteOutcome struct {
+	// Limit is the number of bytes the writer accepted before
+	// failing.
+	Limit int
+
+	// Written is the data the writer accepted.
+	Written []byte
+
+	// Err is the error returned by the function.
+	Err error
+}
+
+// ExploreOptions controls ExploreWriteFailures. The zero value tries
+// every limit and makes no extra checks.
+type ExploreOptions struct {
+	// MaxRuns, if positive, limits the number of failure points
+	// tried. If the output is longer, the first point is always
+	// tried, and the last if MaxRuns is at least 2, along with
+	// others sampled at random.
+	MaxRuns int
+
+	// Seed seeds the choice of sampled points.
+	Seed int64
+
+	// Check, if set, is called after each run, and the test fails
+	// if it returns an error. It can be used to check that the
+	// function left things in a consistent state.
+	Check func(o WriteOutcome) error
+}
+
+// ExploreWriteFailures runs fn against a writer that fails after 0,
+// 1, 2, ... bytes, up to the length of fn's full output, so that every
+// point at which a write can fail is tried. fn is first run against a
+// writer that doesn't fail, to find the length of its output; it must
+// write the same output each time it is called.
+//
+// The test fails if fn panics, if it returns a nil error even though
+// its output was truncated, or if opts.Check reports a problem. The
+// outcome of every run is returned, in order of increasing limit.
+func ExploreWriteFailures(t testing.TB, fn func(w io.Writer) error, opts ExploreOptions) []WriteOutcome {
+	t.Helper()
+
+	full := NewStoringBrokenWriter(math.MaxInt)
+	if err := runExplored(fn, full); err != nil {
+		t.Fatalf("without write failures: %v", err)
+	}
+	size := len(full.Bytes())
+
+	var outcomes []WriteOutcome
+	for _, limit := range explorePoints(size, opts) {
+		w := NewStoringBrokenWriter(limit)
+		o := WriteOutcome{Limit: limit, Err: runExplored(fn, w)}
+		o.Written = w.Bytes()
+		outcomes = append(outcomes, o)
+
+		if _, ok := o.Err.(explorePanic); ok {
+			t.Fatalf("limit %d: %v", limit, o.Err)
+		} else if o.Err == nil {
+			t.Fatalf("limit %d: returned a nil error after writing only %d of %d bytes",
+				limit, len(o.Written), size)
+		}
+
+		if opts.Check != nil {
+			if err := opts.Check(o); err != nil {
+				t.Fatalf("limit %d: %v", limit, err)
+			}
+		}
+	}
+	return outcomes
+}
+
+// explorePanic is the error returned by runExplored if fn panics.
+type explorePanic struct {
+	value interface{}
+}
+
+func (p explorePanic) Error() string {
+	return fmt.Sprintf("panicked: %v", p.value)
+}
+
+// runExplored runs fn, turning a panic into an explorePanic.
+func runExplored(fn func(w io.Writer) error, w io.Writer) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = explorePanic{v}
+		}
+	}()
+	return fn(w)
+}
+
+// explorePoints returns the limits to try for output of size bytes,
+// in increasing order.
+func explorePoints(size int, opts ExploreOptions) []int {
+	if opts.MaxRuns <= 0 || size <= opts.MaxRuns {
+		points := make([]int, size)
+		for i := range points {
+			points[i] = i
+		}
+		return points
+	}
+
+	chosen := map[int]bool{0: true}
+	if opts.MaxRuns > 1 {
+		chosen[size-1] = true
+	}
+	prng := rand.New(rand.NewSource(opts.Seed))
+	for len(chosen) < opts.MaxRuns {
+		chosen[prng.Intn(size)] = true
+	}
+
+	var points []int
+	for limit := range chosen {
+		points = append(points, limit)
+	}
+	sort.Ints(points)
+	return points
+}
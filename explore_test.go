@@ -0,0 +1,95 @@
+package testio
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+// writeRecords writes a few lines through a bufio.Writer, which
+// correctly reports errors from the underlying writer.
+func writeRecords(w io.Writer) error {
+	bw := bufio.NewWriterSize(w, 16)
+	for i := 0; i < 5; i++ {
+		if _, err := fmt.Fprintf(bw, "record %d\n", i); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+func TestExploreWriteFailures(t *testing.T) {
+	outcomes := ExploreWriteFailures(t, writeRecords, ExploreOptions{
+		Check: func(o WriteOutcome) error {
+			if len(o.Written) != o.Limit {
+				return errors.New("expected the writer to be filled to its limit")
+			}
+			return nil
+		},
+	})
+
+	if len(outcomes) != 45 {
+		t.Fatalf("expected 45 outcomes, have %d", len(outcomes))
+	}
+	for i, o := range outcomes {
+		if o.Limit != i || o.Err == nil {
+			t.Fatalf("unexpected outcome %+v", o)
+		}
+	}
+
+	outcomes = ExploreWriteFailures(t, writeRecords, ExploreOptions{MaxRuns: 10, Seed: 1})
+	if len(outcomes) != 10 {
+		t.Fatalf("expected 10 outcomes, have %d", len(outcomes))
+	} else if outcomes[0].Limit != 0 || outcomes[9].Limit != 44 {
+		t.Fatalf("expected the first and last points to be tried, have %d and %d",
+			outcomes[0].Limit, outcomes[9].Limit)
+	}
+
+	outcomes = ExploreWriteFailures(t, writeRecords, ExploreOptions{MaxRuns: 1})
+	if len(outcomes) != 1 || outcomes[0].Limit != 0 {
+		t.Fatalf("expected only the first point to be tried, have %+v", outcomes)
+	}
+}
+
+func TestExploreWriteFailuresDetects(t *testing.T) {
+	failure := expectFailure(t, func(tb testing.TB) {
+		ExploreWriteFailures(tb, func(w io.Writer) error {
+			w.Write([]byte("ignored"))
+			return nil
+		}, ExploreOptions{})
+	})
+	if !strings.Contains(failure, "returned a nil error") {
+		t.Fatalf("expected an ignored error to be caught, have '%s'", failure)
+	}
+
+	failure = expectFailure(t, func(tb testing.TB) {
+		ExploreWriteFailures(tb, func(w io.Writer) error {
+			n, err := w.Write([]byte("header"))
+			if err != nil {
+				var missing []byte
+				_ = missing[n]
+			}
+			return err
+		}, ExploreOptions{})
+	})
+	if !strings.Contains(failure, "limit 0: panicked") {
+		t.Fatalf("expected a panic to be caught, have '%s'", failure)
+	}
+
+	failure = expectFailure(t, func(tb testing.TB) {
+		ExploreWriteFailures(tb, writeRecords, ExploreOptions{
+			Check: func(o WriteOutcome) error {
+				if o.Limit == 3 {
+					return errors.New("inconsistent")
+				}
+				return nil
+			},
+		})
+	})
+	if !strings.Contains(failure, "limit 3: inconsistent") {
+		t.Fatalf("expected the check to fail, have '%s'", failure)
+	}
+}
@@ -0,0 +1,69 @@
+package testio
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// BrokenConn wraps a net.Conn so that writes fail after a certain
+// number of bytes, at which point the underlying connection is
+// closed. The other end sees the data written up to the limit,
+// followed by io.EOF, which simulates a connection that breaks in
+// the middle of a message.
+type BrokenConn struct {
+	net.Conn
+
+	mu             sync.Mutex
+	current, limit int
+}
+
+// NewBrokenConn wraps c in a BrokenConn that can write only limit
+// bytes.
+func NewBrokenConn(c net.Conn, limit int) *BrokenConn {
+	return &BrokenConn{Conn: c, limit: limit}
+}
+
+// Write writes to the underlying connection, closing it once the
+// limit has been reached.
+func (bc *BrokenConn) Write(p []byte) (int, error) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	remain := bc.limit - bc.current
+	if len(p) <= remain {
+		n, err := bc.Conn.Write(p)
+		bc.current += n
+		return n, err
+	}
+
+	var n int
+	if remain > 0 {
+		n, _ = bc.Conn.Write(p[:remain])
+		bc.current += n
+	}
+	bc.Conn.Close()
+	return n, errors.New("testio: write failed")
+}
+
+// SlowConn wraps a net.Conn so that every write is delayed. It can be
+// used to simulate a slow peer, such as a server that takes a long
+// time to send its response headers.
+type SlowConn struct {
+	net.Conn
+	delay time.Duration
+}
+
+// NewSlowConn wraps c in a SlowConn that waits for delay before each
+// write.
+func NewSlowConn(c net.Conn, delay time.Duration) *SlowConn {
+	return &SlowConn{Conn: c, delay: delay}
+}
+
+// Write waits for the delay, then writes to the underlying
+// connection.
+func (sc *SlowConn) Write(p []byte) (int, error) {
+	time.Sleep(sc.delay)
+	return sc.Conn.Write(p)
+}
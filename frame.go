@@ -0,0 +1,234 @@
+package testio
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"strings"
+	"sync"
+)
+
+// LinePeer reads and writes whole lines over an io.ReadWriter, such as
+// the Peer of a BufferConn. It allows scripted peers for line-based
+// protocols such as SMTP or IRC to be written a line at a time. It is
+// safe for concurrent use; reading and writing don't block each other.
+type LinePeer struct {
+	rw io.ReadWriter
+
+	rmu sync.Mutex
+	r   *bufio.Reader
+
+	wmu sync.Mutex
+	eol string
+}
+
+// NewLinePeer creates a LinePeer on rw. Lines are written with a
+// "\r\n" ending, which may be changed with SetLineEnding.
+func NewLinePeer(rw io.ReadWriter) *LinePeer {
+	return &LinePeer{
+		rw:  rw,
+		r:   bufio.NewReader(rw),
+		eol: "\r\n",
+	}
+}
+
+// SetLineEnding sets the line ending used by WriteLine.
+func (lp *LinePeer) SetLineEnding(eol string) {
+	lp.wmu.Lock()
+	lp.eol = eol
+	lp.wmu.Unlock()
+}
+
+// ReadLine reads the next line, without its "\n" or "\r\n" ending. If
+// the stream ends in the middle of a line, the partial line is
+// returned with io.ErrUnexpectedEOF.
+func (lp *LinePeer) ReadLine() (string, error) {
+	lp.rmu.Lock()
+	defer lp.rmu.Unlock()
+
+	line, err := lp.r.ReadString('\n')
+	if err == io.EOF && line != "" {
+		return line, io.ErrUnexpectedEOF
+	} else if err != nil {
+		return line, err
+	}
+
+	line = strings.TrimSuffix(line, "\n")
+	return strings.TrimSuffix(line, "\r"), nil
+}
+
+// WriteLine writes line followed by the line ending.
+func (lp *LinePeer) WriteLine(line string) error {
+	lp.wmu.Lock()
+	defer lp.wmu.Unlock()
+
+	_, err := io.WriteString(lp.rw, line+lp.eol)
+	return err
+}
+
+// A FrameFormat describes how a FramePeer separates frames.
+type FrameFormat int
+
+const (
+	// FrameUvarint prefixes each frame with its length as an
+	// unsigned varint.
+	FrameUvarint FrameFormat = iota
+
+	// FrameUint16 prefixes each frame with its length as a
+	// big-endian uint16.
+	FrameUint16
+
+	// FrameUint32 prefixes each frame with its length as a
+	// big-endian uint32.
+	FrameUint32
+
+	// FrameDelimited ends each frame with a delimiter byte.
+	FrameDelimited
+)
+
+// DefaultMaxFrame is the largest frame a FramePeer will read, unless
+// SetMaxFrame has been called.
+const DefaultMaxFrame = 16 << 20
+
+// FramePeer reads and writes whole frames over an io.ReadWriter, such
+// as the Peer of a BufferConn, so that scripted peers for
+// message-based protocols can be written a message at a time. It is
+// safe for concurrent use; reading and writing don't block each other.
+type FramePeer struct {
+	rw     io.ReadWriter
+	format FrameFormat
+
+	// rmu is held while reading, and wmu while writing; both are
+	// held to change the settings.
+	rmu, wmu sync.Mutex
+	r        *bufio.Reader
+	delim    byte
+	max      int
+}
+
+// NewFramePeer creates a FramePeer on rw using the given format. For
+// FrameDelimited, the delimiter is "\n" unless SetDelimiter is called.
+func NewFramePeer(rw io.ReadWriter, format FrameFormat) *FramePeer {
+	return &FramePeer{
+		rw:     rw,
+		r:      bufio.NewReader(rw),
+		format: format,
+		delim:  '\n',
+		max:    DefaultMaxFrame,
+	}
+}
+
+// SetDelimiter sets the byte that ends each frame in the
+// FrameDelimited format.
+func (fp *FramePeer) SetDelimiter(delim byte) {
+	fp.rmu.Lock()
+	fp.wmu.Lock()
+	fp.delim = delim
+	fp.wmu.Unlock()
+	fp.rmu.Unlock()
+}
+
+// SetMaxFrame sets the largest frame that ReadFrame will accept.
+func (fp *FramePeer) SetMaxFrame(n int) {
+	fp.rmu.Lock()
+	fp.max = n
+	fp.rmu.Unlock()
+}
+
+// ReadFrame reads the next frame. If the stream ends in the middle of
+// a frame, it returns io.ErrUnexpectedEOF.
+func (fp *FramePeer) ReadFrame() ([]byte, error) {
+	fp.rmu.Lock()
+	defer fp.rmu.Unlock()
+
+	if fp.format == FrameDelimited {
+		frame, err := fp.r.ReadBytes(fp.delim)
+		if err == io.EOF && len(frame) > 0 {
+			return frame, io.ErrUnexpectedEOF
+		} else if err != nil {
+			return frame, err
+		}
+
+		frame = frame[:len(frame)-1]
+		if len(frame) > fp.max {
+			return nil, errors.New("testio: frame too large")
+		}
+		return frame, nil
+	}
+
+	var size uint64
+	switch fp.format {
+	case FrameUvarint:
+		n, err := binary.ReadUvarint(fp.r)
+		if err != nil {
+			return nil, err
+		}
+		size = n
+	case FrameUint16, FrameUint32:
+		prefix := make([]byte, 4)
+		if fp.format == FrameUint16 {
+			prefix = prefix[:2]
+		}
+
+		if _, err := io.ReadFull(fp.r, prefix); err != nil {
+			return nil, err
+		}
+
+		if fp.format == FrameUint16 {
+			size = uint64(binary.BigEndian.Uint16(prefix))
+		} else {
+			size = uint64(binary.BigEndian.Uint32(prefix))
+		}
+	default:
+		return nil, errors.New("testio: unknown frame format")
+	}
+
+	if size > uint64(fp.max) {
+		return nil, errors.New("testio: frame too large")
+	}
+
+	frame := make([]byte, size)
+	_, err := io.ReadFull(fp.r, frame)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return frame, err
+}
+
+// WriteFrame writes p as a single frame.
+func (fp *FramePeer) WriteFrame(p []byte) error {
+	fp.wmu.Lock()
+	defer fp.wmu.Unlock()
+
+	var prefix []byte
+	switch fp.format {
+	case FrameUvarint:
+		prefix = binary.AppendUvarint(nil, uint64(len(p)))
+	case FrameUint16:
+		if len(p) > math.MaxUint16 {
+			return errors.New("testio: frame too large")
+		}
+		prefix = binary.BigEndian.AppendUint16(nil, uint16(len(p)))
+	case FrameUint32:
+		if uint64(len(p)) > math.MaxUint32 {
+			return errors.New("testio: frame too large")
+		}
+		prefix = binary.BigEndian.AppendUint32(nil, uint32(len(p)))
+	case FrameDelimited:
+		for _, b := range p {
+			if b == fp.delim {
+				return errors.New("testio: frame contains the delimiter")
+			}
+		}
+
+		_, err := fp.rw.Write(append(append([]byte(nil), p...), fp.delim))
+		return err
+	default:
+		return errors.New("testio: unknown frame format")
+	}
+
+	_, err := fp.rw.Write(append(prefix, p...))
+	return err
+}
@@ -0,0 +1,123 @@
+package testio
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"testing"
+)
+
+func TestLinePeer(t *testing.T) {
+	client, _ := NewBufferConnPair()
+	peer := NewLinePeer(client.Peer())
+
+	go func() {
+		line, err := peer.ReadLine()
+		if err != nil || line != "HELO client" {
+			peer.WriteLine("500 unexpected " + line)
+			return
+		}
+		peer.WriteLine("250 Hello client")
+	}()
+
+	_, err := client.Write([]byte("HELO client\r\n"))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	lp := NewLinePeer(client)
+	line, err := lp.ReadLine()
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if line != "250 Hello client" {
+		t.Fatalf("expected '250 Hello client', have '%s'", line)
+	}
+
+	client.Write([]byte("partial"))
+	client.Close()
+	_, err = peer.ReadLine()
+	if err != io.ErrUnexpectedEOF {
+		t.Fatalf("expected a partial line to fail, have %v", err)
+	}
+}
+
+func TestFramePeer(t *testing.T) {
+	formats := []FrameFormat{FrameUvarint, FrameUint16, FrameUint32, FrameDelimited}
+	frames := [][]byte{[]byte("first"), {}, bytes.Repeat([]byte("A"), 300)}
+
+	for _, format := range formats {
+		c1, c2 := NewBufferConnPair()
+		w := NewFramePeer(c1, format)
+		r := NewFramePeer(c2, format)
+		if format == FrameDelimited {
+			w.SetDelimiter(0)
+			r.SetDelimiter(0)
+		}
+
+		for _, frame := range frames {
+			if err := w.WriteFrame(frame); err != nil {
+				t.Fatalf("format %d: %v", format, err)
+			}
+		}
+
+		for _, frame := range frames {
+			have, err := r.ReadFrame()
+			if err != nil {
+				t.Fatalf("format %d: %v", format, err)
+			} else if !bytes.Equal(have, frame) {
+				t.Fatalf("format %d: expected %x, have %x", format, frame, have)
+			}
+		}
+
+		r.SetMaxFrame(100)
+		w.WriteFrame(frames[2])
+		if _, err := r.ReadFrame(); err == nil {
+			t.Fatalf("format %d: expected an oversized frame to fail", format)
+		}
+	}
+
+	c1, _ := NewBufferConnPair()
+	w := NewFramePeer(c1, FrameUint16)
+	if err := w.WriteFrame(make([]byte, 1<<16)); err == nil {
+		t.Fatal("expected a frame too large for its prefix to fail")
+	}
+
+	w = NewFramePeer(c1, FrameDelimited)
+	if err := w.WriteFrame([]byte("a\nb")); err == nil {
+		t.Fatal("expected a frame containing the delimiter to fail")
+	}
+}
+
+func TestFramePeerConcurrent(t *testing.T) {
+	client, _ := NewBufferConnPair()
+	defer client.Close()
+
+	writer := NewFramePeer(client.Peer(), FrameUint16)
+	reader := NewFramePeer(client, FrameUint16)
+
+	const frames = 100
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < frames; j++ {
+				if err := writer.WriteFrame([]byte("frame")); err != nil {
+					t.Errorf("%v", err)
+				}
+			}
+		}()
+	}
+
+	// Each frame must arrive whole, whichever goroutine sent it.
+	for i := 0; i < 4*frames; i++ {
+		frame, err := reader.ReadFrame()
+		if err != nil {
+			t.Fatalf("%v", err)
+		} else if string(frame) != "frame" {
+			t.Fatalf("expected 'frame', have '%s'", frame)
+		}
+		reader.SetMaxFrame(DefaultMaxFrame)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,252 @@
+package testio
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"testing"
+)
+
+// Limits on the faults that can be decoded from fuzz input, to keep
+// plans small enough for the fuzzer to explore.
+const (
+	maxPlanChunks      = 4
+	maxPlanCorruptions = 4
+)
+
+// A FaultPlan is a reproducible schedule of faults, usually decoded
+// from the input of a fuzz test with DecodeFaultPlan. This lets Go's
+// fuzzer explore the space of I/O failures, and minimise the schedules
+// that make a test fail:
+//
+//	func FuzzParser(f *testing.F) {
+//		testio.AddFaultPlanSeeds(f)
+//		f.Fuzz(func(t *testing.T, data []byte) {
+//			plan := testio.DecodeFaultPlan(data)
+//			r := plan.Reader(bytes.NewReader(input))
+//			...
+//		})
+//	}
+type FaultPlan struct {
+	// ChunkSizes limits the size of successive reads and writes,
+	// cycling through the list. If it is empty, calls aren't
+	// split.
+	ChunkSizes []int
+
+	// ReadErrorAt and WriteErrorAt are the number of bytes that
+	// may be read or written before reads or writes fail. A
+	// negative value means they never fail.
+	ReadErrorAt, WriteErrorAt int
+
+	// Corruptions are made to the data read or written, at the
+	// offsets given. Only the Kind, Offset, Len and Bit fields are
+	// used.
+	Corruptions []Corruption
+}
+
+// planDecoder reads values from fuzz input. Once the input is
+// exhausted, it returns zeros, so that every input decodes to a plan.
+type planDecoder struct {
+	data []byte
+}
+
+func (d *planDecoder) byte() byte {
+	if len(d.data) == 0 {
+		return 0
+	}
+	b := d.data[0]
+	d.data = d.data[1:]
+	return b
+}
+
+func (d *planDecoder) uint16() int {
+	return int(d.byte())<<8 | int(d.byte())
+}
+
+// DecodeFaultPlan decodes a FaultPlan from arbitrary data, such as the
+// input to a fuzz test. Every input decodes to a valid plan, and the
+// same input always decodes to the same plan. Empty input decodes to
+// a plan with no faults.
+func DecodeFaultPlan(data []byte) FaultPlan {
+	d := &planDecoder{data: data}
+	plan := FaultPlan{ReadErrorAt: -1, WriteErrorAt: -1}
+
+	for n := int(d.byte()) % (maxPlanChunks + 1); n > 0; n-- {
+		plan.ChunkSizes = append(plan.ChunkSizes, int(d.byte())%64+1)
+	}
+
+	if d.byte()&1 == 1 {
+		plan.ReadErrorAt = d.uint16()
+	}
+	if d.byte()&1 == 1 {
+		plan.WriteErrorAt = d.uint16()
+	}
+
+	for n := int(d.byte()) % (maxPlanCorruptions + 1); n > 0; n-- {
+		c := Corruption{
+			Kind:   CorruptionKind(d.byte() % 4),
+			Offset: int64(d.uint16()),
+		}
+		b := d.byte()
+		c.Len, c.Bit = int(b%8)+1, uint(b>>3)%8
+		if c.Kind == CorruptFlip {
+			c.Len = 1
+		}
+		plan.Corruptions = append(plan.Corruptions, c)
+	}
+	return plan
+}
+
+// Encode returns data that DecodeFaultPlan decodes to the plan, so
+// that interesting plans can be added to a fuzz test's seed corpus.
+// Values outside the ranges that can be decoded are clamped.
+func (p FaultPlan) Encode() []byte {
+	clamp := func(v, lo, hi int) int {
+		if v < lo {
+			return lo
+		} else if v > hi {
+			return hi
+		}
+		return v
+	}
+
+	chunks := p.ChunkSizes
+	if len(chunks) > maxPlanChunks {
+		chunks = chunks[:maxPlanChunks]
+	}
+	data := []byte{byte(len(chunks))}
+	for _, size := range chunks {
+		data = append(data, byte(clamp(size, 1, 64)-1))
+	}
+
+	for _, at := range []int{p.ReadErrorAt, p.WriteErrorAt} {
+		if at < 0 {
+			data = append(data, 0)
+			continue
+		}
+		data = append(data, 1)
+		data = binary.BigEndian.AppendUint16(data, uint16(clamp(at, 0, 0xffff)))
+	}
+
+	corruptions := p.Corruptions
+	if len(corruptions) > maxPlanCorruptions {
+		corruptions = corruptions[:maxPlanCorruptions]
+	}
+	data = append(data, byte(len(corruptions)))
+	for _, c := range corruptions {
+		data = append(data, byte(c.Kind)%4)
+		data = binary.BigEndian.AppendUint16(data, uint16(clamp(int(c.Offset), 0, 0xffff)))
+		data = append(data, byte(clamp(c.Len, 1, 8)-1)|byte(c.Bit%8)<<3)
+	}
+	return data
+}
+
+// AddFaultPlanSeeds adds a set of encoded plans covering the common
+// kinds of fault to the seed corpus of f.
+func AddFaultPlanSeeds(f *testing.F) {
+	plans := []FaultPlan{
+		{ReadErrorAt: -1, WriteErrorAt: -1},
+		{ChunkSizes: []int{1}, ReadErrorAt: -1, WriteErrorAt: -1},
+		{ChunkSizes: []int{3, 1, 7}, ReadErrorAt: -1, WriteErrorAt: -1},
+		{ReadErrorAt: 0, WriteErrorAt: 0},
+		{ReadErrorAt: 5, WriteErrorAt: 5},
+		{ReadErrorAt: -1, WriteErrorAt: -1, Corruptions: []Corruption{{Kind: CorruptFlip, Offset: 0}}},
+		{ReadErrorAt: -1, WriteErrorAt: -1, Corruptions: []Corruption{{Kind: CorruptDrop, Offset: 2, Len: 2}}},
+		{ReadErrorAt: -1, WriteErrorAt: -1, Corruptions: []Corruption{{Kind: CorruptDuplicate, Offset: 1, Len: 3}}},
+	}
+
+	for _, plan := range plans {
+		f.Add(plan.Encode())
+	}
+}
+
+// planChunkPolicy limits successive reads and writes to the plan's
+// chunk sizes.
+type planChunkPolicy struct {
+	mu    sync.Mutex
+	sizes []int
+	next  int
+}
+
+func (cp *planChunkPolicy) Before(c *Call) error {
+	if c.Op != OpRead && c.Op != OpWrite {
+		return nil
+	}
+
+	cp.mu.Lock()
+	size := cp.sizes[cp.next%len(cp.sizes)]
+	cp.next++
+	cp.mu.Unlock()
+
+	if len(c.Buf) > size {
+		c.Buf = c.Buf[:size]
+	}
+	return nil
+}
+
+func (cp *planChunkPolicy) After(c *Call) {}
+
+// Policies returns Interceptor policies that apply the plan's chunk
+// sizes and errors. Corruption can't be applied by a policy; it is
+// applied by Reader, Writer and Conn.
+func (p FaultPlan) Policies() []Policy {
+	var policies []Policy
+	if p.ReadErrorAt >= 0 || p.WriteErrorAt >= 0 {
+		policies = append(policies, NewLimitPolicy(p.WriteErrorAt, p.ReadErrorAt))
+	}
+	if len(p.ChunkSizes) > 0 {
+		policies = append(policies, &planChunkPolicy{sizes: p.ChunkSizes})
+	}
+	return policies
+}
+
+// corrupter returns a Corrupter for the plan's corruptions.
+func (p FaultPlan) corrupter() *Corrupter {
+	c := NewCorrupter(0)
+	for _, pc := range p.Corruptions {
+		switch pc.Kind {
+		case CorruptFlip:
+			c.FlipBit(pc.Offset, pc.Bit)
+		case CorruptZero:
+			c.Zero(pc.Offset, pc.Len)
+		case CorruptDuplicate:
+			c.Duplicate(pc.Offset, pc.Len)
+		case CorruptDrop:
+			c.Drop(pc.Offset, pc.Len)
+		}
+	}
+	return c
+}
+
+// Reader wraps r so that reads from it follow the plan. Corruption
+// offsets are counted in the data read from r, and the read error
+// offset in the data returned to the caller.
+func (p FaultPlan) Reader(r io.Reader) io.Reader {
+	return NewInterceptor(p.corrupter().Reader(r), p.Policies()...)
+}
+
+// Writer wraps w so that writes to it follow the plan. Offsets for
+// errors and corruption are counted in the data written by the
+// caller.
+func (p FaultPlan) Writer(w io.Writer) io.Writer {
+	return NewInterceptor(p.corrupter().Writer(w), p.Policies()...)
+}
+
+// planConn is a net.Conn whose reads are corrupted.
+type planConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (pc *planConn) Read(p []byte) (int, error) {
+	return pc.r.Read(p)
+}
+
+// Conn wraps c, which may be a BufferConn, so that its reads and
+// writes follow the plan. Corruption is applied to the data read from
+// c, as if it had been damaged in transit.
+func (p FaultPlan) Conn(c net.Conn) net.Conn {
+	pc := &planConn{Conn: c, r: p.corrupter().Reader(c)}
+	return NewInterceptor(pc, p.Policies()...)
+}
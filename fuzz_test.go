@@ -0,0 +1,90 @@
+package testio
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestFaultPlanEncode(t *testing.T) {
+	plan := FaultPlan{
+		ChunkSizes:   []int{1, 64},
+		ReadErrorAt:  300,
+		WriteErrorAt: -1,
+		Corruptions: []Corruption{
+			{Kind: CorruptFlip, Offset: 7, Len: 1, Bit: 5},
+			{Kind: CorruptDrop, Offset: 2, Len: 8},
+		},
+	}
+
+	decoded := DecodeFaultPlan(plan.Encode())
+	if !reflect.DeepEqual(decoded, plan) {
+		t.Fatalf("expected %+v, have %+v", plan, decoded)
+	}
+
+	empty := DecodeFaultPlan(nil)
+	if len(empty.ChunkSizes) != 0 || empty.ReadErrorAt >= 0 ||
+		empty.WriteErrorAt >= 0 || len(empty.Corruptions) != 0 {
+		t.Fatalf("expected empty input to decode to a plan with no faults, have %+v", empty)
+	}
+}
+
+func TestFaultPlanConn(t *testing.T) {
+	plan := FaultPlan{
+		ChunkSizes:   []int{2},
+		ReadErrorAt:  -1,
+		WriteErrorAt: 3,
+		Corruptions:  []Corruption{{Kind: CorruptZero, Offset: 1, Len: 1}},
+	}
+
+	bc := NewBufferConn()
+	conn := plan.Conn(bc)
+	n, err := conn.Write([]byte("ABCD"))
+	if err == nil {
+		t.Fatal("expected a write failure")
+	} else if n != 3 {
+		t.Fatalf("expected write size of 3, have %d", n)
+	}
+
+	bc.WritePeer([]byte("XYZ"))
+	p := make([]byte, 4)
+	n, _ = conn.Read(p)
+	if string(p[:n]) != "X\x00" {
+		t.Fatalf("expected a chunked, corrupted read, have %q", p[:n])
+	}
+}
+
+var fuzzPayload = []byte("the quick brown fox jumps over the lazy dog")
+
+func FuzzFaultPlan(f *testing.F) {
+	AddFaultPlanSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		plan := DecodeFaultPlan(data)
+		corrupt := len(plan.Corruptions) > 0
+
+		out, err := io.ReadAll(plan.Reader(bytes.NewReader(fuzzPayload)))
+		switch {
+		case plan.ReadErrorAt >= 0 && plan.ReadErrorAt < len(out):
+			t.Fatalf("read %d bytes past the error offset %d", len(out), plan.ReadErrorAt)
+		case err != nil && plan.ReadErrorAt < 0:
+			t.Fatalf("unexpected read error: %v", err)
+		case !corrupt && err == nil && !bytes.Equal(out, fuzzPayload):
+			t.Fatalf("expected %q, have %q", fuzzPayload, out)
+		}
+
+		buf := &bytes.Buffer{}
+		n, err := plan.Writer(buf).Write(fuzzPayload)
+		switch {
+		case plan.WriteErrorAt >= 0 && plan.WriteErrorAt < len(fuzzPayload):
+			if err == nil || n != plan.WriteErrorAt {
+				t.Fatalf("expected to write %d bytes and fail, have (%d, %v)",
+					plan.WriteErrorAt, n, err)
+			}
+		case err != nil:
+			t.Fatalf("unexpected write error: %v", err)
+		case !corrupt && !bytes.Equal(buf.Bytes(), fuzzPayload):
+			t.Fatalf("expected %q, have %q", fuzzPayload, buf.Bytes())
+		}
+	})
+}
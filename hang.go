@@ -0,0 +1,239 @@
+package testio
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// hang is the blocking state shared by HangingReader and
+// HangingWriter.
+type hang struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	released bool
+	closed   bool
+	ctx      context.Context
+	stop     func() bool
+	blocked  int
+}
+
+func (h *hang) init() {
+	h.cond = sync.NewCond(&h.mu)
+}
+
+// err returns the error that calls should fail with, if any. The
+// caller must hold h.mu.
+func (h *hang) err() error {
+	switch {
+	case h.closed:
+		return io.ErrClosedPipe
+	case h.ctx != nil && h.ctx.Err() != nil:
+		return h.ctx.Err()
+	}
+	return nil
+}
+
+// wait blocks until the hang is released, returning nil, or until it
+// is closed or its context is done, returning an error. The caller
+// must hold h.mu.
+func (h *hang) wait() error {
+	h.blocked++
+	defer func() { h.blocked-- }()
+
+	for {
+		if err := h.err(); err != nil {
+			return err
+		} else if h.released {
+			return nil
+		}
+		h.cond.Wait()
+	}
+}
+
+func (h *hang) release() {
+	h.mu.Lock()
+	h.released = true
+	h.cond.Broadcast()
+	h.mu.Unlock()
+}
+
+func (h *hang) close() {
+	h.mu.Lock()
+	h.closed = true
+	if h.stop != nil {
+		h.stop()
+	}
+	h.cond.Broadcast()
+	h.mu.Unlock()
+}
+
+func (h *hang) setContext(ctx context.Context) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.stop != nil {
+		h.stop()
+	}
+	h.ctx = ctx
+	h.stop = context.AfterFunc(ctx, func() {
+		h.mu.Lock()
+		h.cond.Broadcast()
+		h.mu.Unlock()
+	})
+	h.cond.Broadcast()
+}
+
+func (h *hang) numBlocked() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.blocked
+}
+
+// HangingReader is an io.Reader that returns a prefix of data, then
+// blocks until it is released, closed, or its context is done. This
+// can be used to simulate a peer that stops responding, to test
+// watchdogs and idle timeouts. It is safe for concurrent use.
+type HangingReader struct {
+	h      hang
+	prefix []byte
+}
+
+// NewHangingReader creates a HangingReader that returns prefix before
+// hanging. The prefix may be empty.
+func NewHangingReader(prefix []byte) *HangingReader {
+	hr := &HangingReader{prefix: append([]byte(nil), prefix...)}
+	hr.h.init()
+	return hr
+}
+
+// Read returns any of the prefix that hasn't been read yet, then
+// blocks. Once the reader has been released, Read returns io.EOF;
+// once it has been closed, io.ErrClosedPipe; and once its context is
+// done, the context's error.
+func (hr *HangingReader) Read(p []byte) (int, error) {
+	hr.h.mu.Lock()
+	defer hr.h.mu.Unlock()
+
+	if err := hr.h.err(); err != nil {
+		return 0, err
+	}
+
+	if len(hr.prefix) > 0 {
+		n := copy(p, hr.prefix)
+		hr.prefix = hr.prefix[n:]
+		return n, nil
+	}
+
+	if err := hr.h.wait(); err != nil {
+		return 0, err
+	}
+	return 0, io.EOF
+}
+
+// Release unblocks any blocked reads, and stops further reads from
+// blocking.
+func (hr *HangingReader) Release() {
+	hr.h.release()
+}
+
+// Close makes blocked and future reads fail with io.ErrClosedPipe. It
+// always returns nil.
+func (hr *HangingReader) Close() error {
+	hr.h.close()
+	return nil
+}
+
+// SetContext makes blocked and future reads fail with ctx.Err() once
+// ctx is done.
+func (hr *HangingReader) SetContext(ctx context.Context) {
+	hr.h.setContext(ctx)
+}
+
+// Blocked returns the number of goroutines currently blocked in Read.
+func (hr *HangingReader) Blocked() int {
+	return hr.h.numBlocked()
+}
+
+// HangingWriter is an io.Writer that accepts a number of bytes, then
+// blocks until it is released, closed, or its context is done. It is
+// safe for concurrent use.
+type HangingWriter struct {
+	h     hang
+	buf   []byte
+	limit int
+}
+
+// NewHangingWriter creates a HangingWriter that accepts limit bytes
+// before hanging. The limit may be zero.
+func NewHangingWriter(limit int) *HangingWriter {
+	hw := &HangingWriter{limit: limit}
+	hw.h.init()
+	return hw
+}
+
+// Write accepts as much of p as fits within the limit, then blocks
+// until the writer is released, when the rest of p is accepted. If
+// the writer is closed first, Write fails with io.ErrClosedPipe, and
+// if its context is done, with the context's error.
+func (hw *HangingWriter) Write(p []byte) (int, error) {
+	hw.h.mu.Lock()
+	defer hw.h.mu.Unlock()
+
+	if err := hw.h.err(); err != nil {
+		return 0, err
+	}
+
+	n := len(p)
+	if !hw.h.released {
+		if room := hw.limit - len(hw.buf); room < n {
+			n = room
+		}
+		if n < 0 {
+			n = 0
+		}
+	}
+	hw.buf = append(hw.buf, p[:n]...)
+	if n == len(p) {
+		return n, nil
+	}
+
+	if err := hw.h.wait(); err != nil {
+		return n, err
+	}
+	hw.buf = append(hw.buf, p[n:]...)
+	return len(p), nil
+}
+
+// Bytes returns the data that has been accepted so far.
+func (hw *HangingWriter) Bytes() []byte {
+	hw.h.mu.Lock()
+	defer hw.h.mu.Unlock()
+
+	return append([]byte(nil), hw.buf...)
+}
+
+// Release unblocks any blocked writes, and stops further writes from
+// blocking.
+func (hw *HangingWriter) Release() {
+	hw.h.release()
+}
+
+// Close makes blocked and future writes fail with io.ErrClosedPipe.
+// It always returns nil.
+func (hw *HangingWriter) Close() error {
+	hw.h.close()
+	return nil
+}
+
+// SetContext makes blocked and future writes fail with ctx.Err() once
+// ctx is done.
+func (hw *HangingWriter) SetContext(ctx context.Context) {
+	hw.h.setContext(ctx)
+}
+
+// Blocked returns the number of goroutines currently blocked in Write.
+func (hw *HangingWriter) Blocked() int {
+	return hw.h.numBlocked()
+}
@@ -0,0 +1,122 @@
+package testio
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+// waitBlocked waits for n goroutines to block in b.
+func waitBlocked(t *testing.T, b interface{ Blocked() int }, n int) {
+	t.Helper()
+
+	end := time.Now().Add(time.Second)
+	for b.Blocked() != n {
+		if time.Now().After(end) {
+			t.Fatalf("expected %d blocked goroutines, have %d", n, b.Blocked())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestHangingReader(t *testing.T) {
+	hr := NewHangingReader([]byte("AB"))
+	buf := make([]byte, 4)
+	n, err := hr.Read(buf)
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if string(buf[:n]) != "AB" {
+		t.Fatalf("expected 'AB', have '%s'", buf[:n])
+	}
+
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := hr.Read(buf[:0])
+			errs <- err
+		}()
+	}
+	waitBlocked(t, hr, 2)
+
+	hr.Release()
+	for i := 0; i < 2; i++ {
+		if err = <-errs; err != io.EOF {
+			t.Fatalf("expected io.EOF, have %v", err)
+		}
+	}
+	waitBlocked(t, hr, 0)
+
+	hr = NewHangingReader(nil)
+	go func() {
+		_, err := hr.Read(buf)
+		errs <- err
+	}()
+	waitBlocked(t, hr, 1)
+
+	hr.Close()
+	if err = <-errs; err != io.ErrClosedPipe {
+		t.Fatalf("expected io.ErrClosedPipe, have %v", err)
+	}
+
+	hr = NewHangingReader(nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	hr.SetContext(ctx)
+	go func() {
+		_, err := hr.Read(buf)
+		errs <- err
+	}()
+	waitBlocked(t, hr, 1)
+
+	cancel()
+	if err = <-errs; err != context.Canceled {
+		t.Fatalf("expected context.Canceled, have %v", err)
+	}
+}
+
+func TestHangingWriter(t *testing.T) {
+	hw := NewHangingWriter(2)
+	done := make(chan int)
+	go func() {
+		n, err := hw.Write([]byte("ABCD"))
+		if err != nil {
+			t.Errorf("%v", err)
+		}
+		done <- n
+	}()
+	waitBlocked(t, hw, 1)
+
+	if string(hw.Bytes()) != "AB" {
+		t.Fatalf("expected 'AB', have '%s'", hw.Bytes())
+	}
+
+	hw.Release()
+	if n := <-done; n != 4 {
+		t.Fatalf("expected to write 4 bytes, wrote %d", n)
+	} else if string(hw.Bytes()) != "ABCD" {
+		t.Fatalf("expected 'ABCD', have '%s'", hw.Bytes())
+	}
+
+	hw = NewHangingWriter(1)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	hw.SetContext(ctx)
+	if n, err := hw.Write([]byte("AB")); err != context.Canceled {
+		t.Fatalf("expected context.Canceled, have %v", err)
+	} else if n != 0 {
+		t.Fatalf("expected to write 0 bytes, wrote %d", n)
+	}
+
+	hw = NewHangingWriter(1)
+	errs := make(chan error)
+	go func() {
+		_, err := hw.Write([]byte("AB"))
+		errs <- err
+	}()
+	waitBlocked(t, hw, 1)
+
+	hw.Close()
+	if err := <-errs; err != io.ErrClosedPipe {
+		t.Fatalf("expected io.ErrClosedPipe, have %v", err)
+	}
+}
@@ -0,0 +1,267 @@
+package testio
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+)
+
+// ConnHook is called for each connection accepted by a Listener. It
+// is given the number of the connection, starting from zero, and the
+// server side of the connection; the conn it returns is what Accept
+// returns. A hook can wrap the conn to inject faults, or close it to
+// simulate a server that drops connections.
+type ConnHook func(n int, c net.Conn) net.Conn
+
+// Listener is an in-memory net.Listener. Connections are made to it
+// with Dial or DialContext, which return the client side of a
+// BufferConn pair; Accept returns the server side. It allows servers
+// such as http.Server to be run in tests without opening sockets.
+type Listener struct {
+	addr  Addr
+	conns chan net.Conn
+	done  chan struct{}
+
+	mu       sync.Mutex
+	closed   bool
+	refuse   bool
+	hook     ConnHook
+	dials    int
+	accepted int
+
+	// unregister removes the listener from its Network, if any.
+	unregister func()
+}
+
+// NewListener creates a new Listener with the given address. At most
+// backlog connections may be waiting to be accepted; further dials
+// block until the server calls Accept. With a backlog of zero, every
+// dial waits for an Accept.
+func NewListener(name string, backlog int) *Listener {
+	if backlog < 0 {
+		backlog = 0
+	}
+
+	return &Listener{
+		addr:  Addr(name),
+		conns: make(chan net.Conn, backlog),
+		done:  make(chan struct{}),
+	}
+}
+
+func (l *Listener) dialError(err error) error {
+	return &net.OpError{
+		Op:   "dial",
+		Net:  l.addr.Network(),
+		Addr: l.addr,
+		Err:  err,
+	}
+}
+
+// Accept waits for and returns the next connection to the listener.
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case <-l.done:
+		return nil, &net.OpError{
+			Op:   "accept",
+			Net:  l.addr.Network(),
+			Addr: l.addr,
+			Err:  net.ErrClosed,
+		}
+	case c := <-l.conns:
+		l.mu.Lock()
+		hook := l.hook
+		n := l.accepted
+		l.accepted++
+		l.mu.Unlock()
+
+		if hook != nil {
+			c = hook(n, c)
+		}
+		return c, nil
+	}
+}
+
+// Close stops the listener. Any blocked Accept calls are unblocked,
+// and connections that haven't been accepted yet are closed.
+func (l *Listener) Close() error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return &net.OpError{
+			Op:   "close",
+			Net:  l.addr.Network(),
+			Addr: l.addr,
+			Err:  net.ErrClosed,
+		}
+	}
+	l.closed = true
+	close(l.done)
+	unregister := l.unregister
+	l.mu.Unlock()
+
+	if unregister != nil {
+		unregister()
+	}
+
+	l.drain()
+	return nil
+}
+
+// drain closes any connections waiting to be accepted.
+func (l *Listener) drain() {
+	for {
+		select {
+		case c := <-l.conns:
+			c.Close()
+		default:
+			return
+		}
+	}
+}
+
+// Addr returns the listener's address.
+func (l *Listener) Addr() net.Addr {
+	return l.addr
+}
+
+// Refuse controls whether the listener refuses new connections. While
+// it is refusing, dials fail with ECONNREFUSED.
+func (l *Listener) Refuse(refuse bool) {
+	l.mu.Lock()
+	l.refuse = refuse
+	l.mu.Unlock()
+}
+
+// SetConnHook sets the hook called on each accepted connection. A nil
+// hook returns connections unchanged.
+func (l *Listener) SetConnHook(hook ConnHook) {
+	l.mu.Lock()
+	l.hook = hook
+	l.mu.Unlock()
+}
+
+// Dial connects to the listener.
+func (l *Listener) Dial() (net.Conn, error) {
+	return l.DialContext(context.Background())
+}
+
+// DialContext connects to the listener. If the listener's backlog is
+// full, it waits until there is room or ctx is done.
+func (l *Listener) DialContext(ctx context.Context) (net.Conn, error) {
+	n, err := l.admit()
+	if err != nil {
+		return nil, err
+	}
+
+	laddr := Addr(fmt.Sprintf("%s-client-%d", l.addr, n))
+	client := newBufferConn(true, laddr, l.addr)
+	if err := l.enqueue(ctx, client.Peer()); err != nil {
+		client.Close()
+		return nil, err
+	}
+	return client, nil
+}
+
+// admit checks that the listener is accepting connections, and
+// returns the number of the new connection.
+func (l *Listener) admit() (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed || l.refuse {
+		return 0, l.dialError(os.NewSyscallError("connect", syscall.ECONNREFUSED))
+	}
+	l.dials++
+	return l.dials - 1, nil
+}
+
+// enqueue queues the server side of a new connection for Accept.
+func (l *Listener) enqueue(ctx context.Context, server net.Conn) error {
+	select {
+	case l.conns <- server:
+		// The listener may have been closed while the conn was
+		// being queued, in which case nothing will accept it.
+		select {
+		case <-l.done:
+			l.drain()
+			return l.dialError(os.NewSyscallError("connect", syscall.ECONNREFUSED))
+		default:
+			return nil
+		}
+	case <-l.done:
+		return l.dialError(os.NewSyscallError("connect", syscall.ECONNREFUSED))
+	case <-ctx.Done():
+		return l.dialError(ctx.Err())
+	}
+}
+
+// Network is a set of named in-memory listeners. Its Dial and
+// DialContext methods have the same signatures as net.Dialer's, so
+// they may be used as the dial function for an http.Transport or a
+// gRPC client.
+type Network struct {
+	mu        sync.Mutex
+	listeners map[string]*Listener
+}
+
+// NewNetwork initialises an empty Network.
+func NewNetwork() *Network {
+	return &Network{listeners: map[string]*Listener{}}
+}
+
+// Listen creates a new Listener on the network with the given
+// address. Since HTTP clients dial "host:port" addresses, name should
+// usually include a port, such as "api:80". It is an error to listen
+// on an address that is already in use.
+func (n *Network) Listen(name string, backlog int) (*Listener, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if _, ok := n.listeners[name]; ok {
+		return nil, &net.OpError{
+			Op:   "listen",
+			Net:  Addr(name).Network(),
+			Addr: Addr(name),
+			Err:  os.NewSyscallError("bind", syscall.EADDRINUSE),
+		}
+	}
+
+	l := NewListener(name, backlog)
+	l.unregister = func() {
+		n.mu.Lock()
+		if n.listeners[name] == l {
+			delete(n.listeners, name)
+		}
+		n.mu.Unlock()
+	}
+	n.listeners[name] = l
+	return l, nil
+}
+
+// Dial connects to the listener at address. The network is ignored.
+func (n *Network) Dial(network, address string) (net.Conn, error) {
+	return n.DialContext(context.Background(), network, address)
+}
+
+// DialContext connects to the listener at address, waiting until ctx
+// is done if the listener's backlog is full. The network is ignored.
+// Dialing an address with no listener fails with ECONNREFUSED.
+func (n *Network) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	n.mu.Lock()
+	l, ok := n.listeners[address]
+	n.mu.Unlock()
+
+	if !ok {
+		return nil, &net.OpError{
+			Op:   "dial",
+			Net:  Addr(address).Network(),
+			Addr: Addr(address),
+			Err:  os.NewSyscallError("connect", syscall.ECONNREFUSED),
+		}
+	}
+	return l.DialContext(ctx)
+}
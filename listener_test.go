@@ -0,0 +1,119 @@
+package testio
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestListenerHTTP(t *testing.T) {
+	network := NewNetwork()
+	ln, err := network.Listen("api:80", 1)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	srv := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, "hello")
+		}),
+	}
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	client := &http.Client{
+		Transport: &http.Transport{DialContext: network.DialContext},
+	}
+
+	resp, err := client.Get("http://api/")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if string(body) != "hello" {
+		t.Fatalf("expected 'hello', have '%s'", body)
+	}
+
+	_, err = network.Listen("api:80", 1)
+	if !errors.Is(err, syscall.EADDRINUSE) {
+		t.Fatalf("expected EADDRINUSE, have %v", err)
+	}
+
+	_, err = network.Dial("tcp", "db:5432")
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		t.Fatalf("expected ECONNREFUSED, have %v", err)
+	}
+}
+
+func TestListener(t *testing.T) {
+	ln := NewListener("server", 1)
+
+	ln.Refuse(true)
+	_, err := ln.Dial()
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		t.Fatalf("expected ECONNREFUSED, have %v", err)
+	}
+	ln.Refuse(false)
+
+	ln.SetConnHook(func(n int, c net.Conn) net.Conn {
+		if n == 0 {
+			c.Close()
+		}
+		return c
+	})
+
+	client, err := ln.Dial()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// The backlog is full, so this dial must wait for an Accept.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = ln.DialContext(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the dial to time out, have %v", err)
+	}
+
+	server, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	_, err = server.Write([]byte("A"))
+	if err == nil {
+		t.Fatal("expected a write to a closed conn to fail")
+	}
+
+	_, err = client.Read(make([]byte, 1))
+	if err != io.EOF {
+		t.Fatalf("expected EOF from a dropped conn, have %v", err)
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := ln.Accept()
+		errs <- err
+	}()
+
+	if err = ln.Close(); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if err = <-errs; !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("expected Accept to fail after Close, have %v", err)
+	}
+
+	_, err = ln.Dial()
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		t.Fatalf("expected ECONNREFUSED, have %v", err)
+	}
+}
@@ -0,0 +1,360 @@
+package testio
+
+import (
+	"io"
+	"math"
+	"os"
+	"sort"
+	"sync"
+	"syscall"
+)
+
+// FS is the filesystem interface used by MemFS. Code that saves files
+// can be written against it so that it can be tested with a MemFS,
+// and use an adapter for the real filesystem in production.
+type FS interface {
+	// Create creates or truncates the named file, opening it for
+	// writing.
+	Create(name string) (File, error)
+
+	// Open opens the named file for reading.
+	Open(name string) (File, error)
+
+	// Rename atomically replaces newname with oldname.
+	Rename(oldname, newname string) error
+
+	// Remove removes the named file.
+	Remove(name string) error
+}
+
+// File is an open file in an FS.
+type File interface {
+	io.ReadWriteCloser
+
+	// Name returns the name the file was opened with.
+	Name() string
+
+	// Sync makes the file's contents durable.
+	Sync() error
+}
+
+// inode holds a file's contents. data is what a reader sees now, and
+// synced is what would survive a crash.
+type inode struct {
+	data, synced []byte
+}
+
+// MemFS is an in-memory FS that can simulate crashes, full disks and
+// failing system calls. Files are stored in a single flat namespace.
+//
+// A crash loses any data that hasn't been made durable by calling
+// Sync on the file, but namespace changes made by Create, Rename and
+// Remove, including truncation, are durable as soon as they return.
+// This is a simplification of real filesystems, which usually also
+// require the directory to be synced.
+//
+// Every call on the FS or on its files counts as an operation. The
+// number of operations, and the number of bytes that may be written,
+// can be limited to make them fail at a precise point. A MemFS is
+// safe for concurrent use.
+type MemFS struct {
+	mu    sync.Mutex
+	files map[string]*inode
+
+	// bw fails writes once the byte limit has been reached, and
+	// written counts the bytes written across all files.
+	bw      *BrokenWriter
+	written int
+
+	// ops is the number of operations so far, and oplimit is the
+	// number allowed, or negative if they're unlimited. failop is
+	// the single operation that should fail, or negative.
+	ops, oplimit, failop int
+
+	// crashOnLimit makes the filesystem stop working once the byte
+	// or operation limit has been reached, as if the machine had
+	// crashed. crashed is set when that happens.
+	crashOnLimit, crashed bool
+}
+
+// NewMemFS returns an empty MemFS with no limits.
+func NewMemFS() *MemFS {
+	return &MemFS{
+		files:   map[string]*inode{},
+		bw:      NewBrokenWriter(math.MaxInt),
+		oplimit: -1,
+		failop:  -1,
+	}
+}
+
+// op accounts for an operation on the filesystem, returning an error
+// if it should fail. The caller must hold fs.mu.
+func (fs *MemFS) op(name, path string) error {
+	n := fs.ops
+	fs.ops++
+
+	switch {
+	case fs.crashed:
+	case fs.oplimit >= 0 && n >= fs.oplimit:
+		fs.crashed = fs.crashOnLimit
+	case n == fs.failop:
+	default:
+		return nil
+	}
+	return &os.PathError{Op: name, Path: path, Err: syscall.EIO}
+}
+
+// SetByteLimit allows only n more bytes to be written, across all
+// files. Writes beyond the limit fail with ENOSPC, as if the disk
+// were full. A negative limit removes it.
+func (fs *MemFS) SetByteLimit(n int) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if n < 0 {
+		n = math.MaxInt
+	}
+	fs.bw = NewBrokenWriter(n)
+}
+
+// SetOpLimit allows only n more operations; any operation after that
+// fails with EIO. A negative limit removes it.
+func (fs *MemFS) SetOpLimit(n int) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	fs.oplimit = -1
+	if n >= 0 {
+		fs.oplimit = fs.ops + n
+	}
+}
+
+// FailOp makes the operation after the next n fail with EIO. Other
+// operations aren't affected.
+func (fs *MemFS) FailOp(n int) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	fs.failop = fs.ops + n
+}
+
+// Ops returns the number of operations made so far.
+func (fs *MemFS) Ops() int {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	return fs.ops
+}
+
+// BytesWritten returns the number of bytes written so far, across all
+// files.
+func (fs *MemFS) BytesWritten() int {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	return fs.written
+}
+
+// Crash returns a new MemFS holding only what would survive if the
+// machine crashed now: every file is reverted to the contents it had
+// when it was last synced. The new MemFS has no limits, and fs itself
+// is unchanged.
+func (fs *MemFS) Crash() *MemFS {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	crashed := NewMemFS()
+	for name, ino := range fs.files {
+		synced := append([]byte(nil), ino.synced...)
+		crashed.files[name] = &inode{data: synced, synced: synced}
+	}
+	return crashed
+}
+
+// ReadFile returns the current contents of the named file. It isn't
+// counted as an operation.
+func (fs *MemFS) ReadFile(name string) ([]byte, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	ino, ok := fs.files[name]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	return append([]byte(nil), ino.data...), nil
+}
+
+// WriteFile sets the contents of the named file and makes them
+// durable. It is intended for setting up a test, so it isn't counted
+// as an operation and ignores any limits.
+func (fs *MemFS) WriteFile(name string, data []byte) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	data = append([]byte(nil), data...)
+	fs.files[name] = &inode{data: data, synced: data}
+}
+
+// Names returns the names of all of the files, in sorted order.
+func (fs *MemFS) Names() []string {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	var names []string
+	for name := range fs.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Create creates or truncates the named file, opening it for writing.
+func (fs *MemFS) Create(name string) (File, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if err := fs.op("open", name); err != nil {
+		return nil, err
+	}
+
+	ino := &inode{}
+	fs.files[name] = ino
+	return &memFile{fs: fs, ino: ino, name: name, write: true}, nil
+}
+
+// Open opens the named file for reading.
+func (fs *MemFS) Open(name string) (File, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if err := fs.op("open", name); err != nil {
+		return nil, err
+	}
+
+	ino, ok := fs.files[name]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	return &memFile{fs: fs, ino: ino, name: name}, nil
+}
+
+// Rename atomically replaces newname with oldname.
+func (fs *MemFS) Rename(oldname, newname string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if err := fs.op("rename", oldname); err != nil {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: syscall.EIO}
+	}
+
+	ino, ok := fs.files[oldname]
+	if !ok {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: os.ErrNotExist}
+	}
+	delete(fs.files, oldname)
+	fs.files[newname] = ino
+	return nil
+}
+
+// Remove removes the named file. Open files remain usable.
+func (fs *MemFS) Remove(name string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if err := fs.op("remove", name); err != nil {
+		return err
+	}
+
+	if _, ok := fs.files[name]; !ok {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrNotExist}
+	}
+	delete(fs.files, name)
+	return nil
+}
+
+// memFile is an open file in a MemFS.
+type memFile struct {
+	fs     *MemFS
+	ino    *inode
+	name   string
+	write  bool
+	off    int
+	closed bool
+}
+
+func (f *memFile) Name() string {
+	return f.name
+}
+
+func (f *memFile) Read(p []byte) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	if err := f.fs.op("read", f.name); err != nil {
+		return 0, err
+	} else if f.closed {
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: os.ErrClosed}
+	} else if f.write {
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: syscall.EBADF}
+	}
+
+	if f.off >= len(f.ino.data) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.ino.data[f.off:])
+	f.off += n
+	return n, nil
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	if err := f.fs.op("write", f.name); err != nil {
+		return 0, err
+	} else if f.closed {
+		return 0, &os.PathError{Op: "write", Path: f.name, Err: os.ErrClosed}
+	} else if !f.write {
+		return 0, &os.PathError{Op: "write", Path: f.name, Err: syscall.EBADF}
+	}
+
+	n, err := f.fs.bw.Write(p)
+	f.fs.written += n
+	f.ino.data = append(f.ino.data, p[:n]...)
+	if err != nil {
+		f.fs.crashed = f.fs.crashOnLimit
+		return n, &os.PathError{Op: "write", Path: f.name, Err: syscall.ENOSPC}
+	}
+	return n, nil
+}
+
+func (f *memFile) Sync() error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	if err := f.fs.op("sync", f.name); err != nil {
+		return err
+	} else if f.closed {
+		return &os.PathError{Op: "sync", Path: f.name, Err: os.ErrClosed}
+	}
+
+	f.ino.synced = append([]byte(nil), f.ino.data...)
+	return nil
+}
+
+func (f *memFile) Close() error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	if err := f.fs.op("close", f.name); err != nil {
+		// As with a real file, the descriptor is released even
+		// if close reports an error.
+		f.closed = true
+		return err
+	} else if f.closed {
+		return &os.PathError{Op: "close", Path: f.name, Err: os.ErrClosed}
+	}
+
+	f.closed = true
+	return nil
+}
@@ -0,0 +1,110 @@
+package testio
+
+import (
+	"errors"
+	"io"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestMemFS(t *testing.T) {
+	fs := NewMemFS()
+	fs.WriteFile("a", []byte("old"))
+
+	f, err := fs.Create("b")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	f.Write([]byte("synced"))
+	if err = f.Sync(); err != nil {
+		t.Fatalf("%v", err)
+	}
+	f.Write([]byte(" unsynced"))
+	f.Close()
+
+	data, err := fs.ReadFile("b")
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if string(data) != "synced unsynced" {
+		t.Fatalf("expected 'synced unsynced', have '%s'", data)
+	}
+
+	if err = fs.Rename("b", "a"); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	crashed := fs.Crash()
+	if names := crashed.Names(); len(names) != 1 || names[0] != "a" {
+		t.Fatalf("expected only 'a' after the rename, have %v", names)
+	}
+
+	f, err = crashed.Open("a")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	data, err = io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if string(data) != "synced" {
+		t.Fatalf("expected only the synced data after a crash, have '%s'", data)
+	}
+
+	if _, err = f.Write([]byte("x")); !errors.Is(err, syscall.EBADF) {
+		t.Fatalf("expected EBADF writing to a read-only file, have %v", err)
+	}
+
+	if err = crashed.Remove("b"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected os.ErrNotExist, have %v", err)
+	}
+
+	// Truncation is durable.
+	crashed.Create("a")
+	data, _ = crashed.Crash().ReadFile("a")
+	if len(data) != 0 {
+		t.Fatalf("expected an empty file after truncation, have '%s'", data)
+	}
+}
+
+func TestMemFSLimits(t *testing.T) {
+	fs := NewMemFS()
+	fs.SetByteLimit(3)
+
+	f, _ := fs.Create("a")
+	n, err := f.Write([]byte("ABCD"))
+	if !errors.Is(err, syscall.ENOSPC) {
+		t.Fatalf("expected ENOSPC, have %v", err)
+	} else if n != 3 {
+		t.Fatalf("expected write size of 3, have %d", n)
+	} else if fs.BytesWritten() != 3 {
+		t.Fatalf("expected 3 bytes written, have %d", fs.BytesWritten())
+	}
+
+	// A full disk doesn't stop other operations.
+	if err = f.Close(); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	fs.FailOp(1)
+	if _, err = fs.Open("a"); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if _, err = fs.Open("a"); !errors.Is(err, syscall.EIO) {
+		t.Fatalf("expected EIO, have %v", err)
+	}
+	if _, err = fs.Open("a"); err != nil {
+		t.Fatalf("only one operation should fail, have %v", err)
+	}
+
+	fs.SetOpLimit(1)
+	fs.Open("a")
+	for i := 0; i < 2; i++ {
+		if _, err = fs.Open("a"); !errors.Is(err, syscall.EIO) {
+			t.Fatalf("expected EIO, have %v", err)
+		}
+	}
+
+	if fs.Ops() != 9 {
+		t.Fatalf("expected 9 operations, have %d", fs.Ops())
+	}
+}
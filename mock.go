@@ -0,0 +1,231 @@
+package testio
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A mockCall is a single expected call to a mock.
+type mockCall struct {
+	op   string
+	data []byte
+	n    int
+	err  error
+}
+
+func (c mockCall) String() string {
+	if c.op == "Close" {
+		return "Close()"
+	}
+	return fmt.Sprintf("%s(%q)", c.op, c.data)
+}
+
+// mockScript is the ordered list of calls a mock expects. Any calls
+// that haven't been made when the test finishes are reported as
+// errors.
+type mockScript struct {
+	t    testing.TB
+	name string
+
+	mu    sync.Mutex
+	calls []mockCall
+	next  int
+}
+
+func newMockScript(t testing.TB, name string) *mockScript {
+	s := &mockScript{t: t, name: name}
+	t.Cleanup(s.verify)
+	return s
+}
+
+func (s *mockScript) expect(c mockCall) {
+	s.mu.Lock()
+	s.calls = append(s.calls, c)
+	s.mu.Unlock()
+}
+
+// take returns the next expected call, reporting an error if it isn't
+// a call to op.
+func (s *mockScript) take(op string) (mockCall, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.next >= len(s.calls) {
+		s.t.Errorf("%s: unexpected call to %s", s.name, op)
+		return mockCall{}, fmt.Errorf("testio: unexpected call to %s", op)
+	}
+
+	c := s.calls[s.next]
+	if c.op != op {
+		s.t.Errorf("%s: unexpected call to %s, expected %s", s.name, op, c)
+		return mockCall{}, fmt.Errorf("testio: unexpected call to %s", op)
+	}
+
+	s.next++
+	return c, nil
+}
+
+func (s *mockScript) read(p []byte) (int, error) {
+	c, err := s.take("Read")
+	if err != nil {
+		return 0, err
+	}
+
+	if len(p) < len(c.data) {
+		s.t.Errorf("%s: Read with a %d byte buffer, expected at least %d bytes for %s",
+			s.name, len(p), len(c.data), c)
+	}
+	return copy(p, c.data), c.err
+}
+
+func (s *mockScript) write(p []byte) (int, error) {
+	c, err := s.take("Write")
+	if err != nil {
+		return 0, err
+	}
+
+	if !bytes.Equal(p, c.data) {
+		s.t.Errorf("%s: Write data %s", s.name, mismatch(c.data, p))
+	}
+	return c.n, c.err
+}
+
+func (s *mockScript) verify() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.next < len(s.calls) {
+		s.t.Errorf("%s: %d expected calls weren't made, starting with %s",
+			s.name, len(s.calls)-s.next, s.calls[s.next])
+	}
+}
+
+// MockReader is an io.Reader that follows a script of expected reads
+// declared by the test. Every expected read must be made, in order,
+// by the time the test finishes; unexpected reads are reported as
+// test errors.
+type MockReader struct {
+	script *mockScript
+}
+
+// NewMockReader creates a MockReader that reports to t.
+func NewMockReader(t testing.TB) *MockReader {
+	return &MockReader{script: newMockScript(t, "MockReader")}
+}
+
+// ExpectRead expects a call to Read, which will fill the buffer with
+// data and return err. It returns the MockReader so that calls may be
+// chained.
+func (m *MockReader) ExpectRead(data []byte, err error) *MockReader {
+	m.script.expect(mockCall{op: "Read", data: data, err: err})
+	return m
+}
+
+// Read follows the next step in the script.
+func (m *MockReader) Read(p []byte) (int, error) {
+	return m.script.read(p)
+}
+
+// MockWriter is an io.Writer that follows a script of expected writes
+// declared by the test. Every expected write must be made, in order,
+// with the expected data, by the time the test finishes.
+type MockWriter struct {
+	script *mockScript
+}
+
+// NewMockWriter creates a MockWriter that reports to t.
+func NewMockWriter(t testing.TB) *MockWriter {
+	return &MockWriter{script: newMockScript(t, "MockWriter")}
+}
+
+// ExpectWrite expects a call to Write with data, which will return
+// (n, err). It returns the MockWriter so that calls may be chained.
+func (m *MockWriter) ExpectWrite(data []byte, n int, err error) *MockWriter {
+	m.script.expect(mockCall{op: "Write", data: data, n: n, err: err})
+	return m
+}
+
+// Write follows the next step in the script.
+func (m *MockWriter) Write(p []byte) (int, error) {
+	return m.script.write(p)
+}
+
+// MockConn is a net.Conn that follows a script of expected reads,
+// writes and closes declared by the test, checking that they happen
+// in order. Deadlines are accepted but ignored.
+type MockConn struct {
+	script *mockScript
+}
+
+// NewMockConn creates a MockConn that reports to t.
+func NewMockConn(t testing.TB) *MockConn {
+	return &MockConn{script: newMockScript(t, "MockConn")}
+}
+
+// ExpectRead expects a call to Read, which will fill the buffer with
+// data and return err.
+func (m *MockConn) ExpectRead(data []byte, err error) *MockConn {
+	m.script.expect(mockCall{op: "Read", data: data, err: err})
+	return m
+}
+
+// ExpectWrite expects a call to Write with data, which will return
+// (n, err).
+func (m *MockConn) ExpectWrite(data []byte, n int, err error) *MockConn {
+	m.script.expect(mockCall{op: "Write", data: data, n: n, err: err})
+	return m
+}
+
+// ExpectClose expects a call to Close, which will return err.
+func (m *MockConn) ExpectClose(err error) *MockConn {
+	m.script.expect(mockCall{op: "Close", err: err})
+	return m
+}
+
+// Read follows the next step in the script.
+func (m *MockConn) Read(p []byte) (int, error) {
+	return m.script.read(p)
+}
+
+// Write follows the next step in the script.
+func (m *MockConn) Write(p []byte) (int, error) {
+	return m.script.write(p)
+}
+
+// Close follows the next step in the script.
+func (m *MockConn) Close() error {
+	c, err := m.script.take("Close")
+	if err != nil {
+		return err
+	}
+	return c.err
+}
+
+// LocalAddr returns a placeholder address.
+func (m *MockConn) LocalAddr() net.Addr {
+	return Addr("mock-local")
+}
+
+// RemoteAddr returns a placeholder address.
+func (m *MockConn) RemoteAddr() net.Addr {
+	return Addr("mock-remote")
+}
+
+// SetDeadline is a no-op.
+func (m *MockConn) SetDeadline(t time.Time) error {
+	return nil
+}
+
+// SetReadDeadline is a no-op.
+func (m *MockConn) SetReadDeadline(t time.Time) error {
+	return nil
+}
+
+// SetWriteDeadline is a no-op.
+func (m *MockConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
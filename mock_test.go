@@ -0,0 +1,75 @@
+package testio
+
+import (
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestMockReader(t *testing.T) {
+	mr := NewMockReader(t).
+		ExpectRead([]byte("AB"), nil).
+		ExpectRead([]byte("C"), io.EOF)
+
+	data, err := io.ReadAll(mr)
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if string(data) != "ABC" {
+		t.Fatalf("expected 'ABC', have '%s'", data)
+	}
+
+	tb := &fakeTB{TB: t}
+	mr = NewMockReader(tb).ExpectRead([]byte("AB"), nil)
+	mr.Read(make([]byte, 2))
+	mr.Read(make([]byte, 2))
+	if !strings.Contains(tb.failure(), "unexpected call to Read") {
+		t.Fatalf("expected an unexpected Read, have '%s'", tb.failure())
+	}
+}
+
+func TestMockWriter(t *testing.T) {
+	failed := errors.New("disk full")
+	mw := NewMockWriter(t).
+		ExpectWrite([]byte("AB"), 2, nil).
+		ExpectWrite([]byte("CD"), 1, failed)
+
+	n, err := mw.Write([]byte("AB"))
+	if err != nil || n != 2 {
+		t.Fatalf("expected (2, nil), have (%d, %v)", n, err)
+	}
+
+	n, err = mw.Write([]byte("CD"))
+	if err != failed || n != 1 {
+		t.Fatalf("expected (1, %v), have (%d, %v)", failed, n, err)
+	}
+
+	tb := &fakeTB{TB: t}
+	mw = NewMockWriter(tb).ExpectWrite([]byte("AB"), 2, nil)
+	mw.Write([]byte("AC"))
+	if !strings.Contains(tb.failure(), "differs at byte 1") {
+		t.Fatalf("expected a data mismatch, have '%s'", tb.failure())
+	}
+}
+
+func TestMockConn(t *testing.T) {
+	var _ net.Conn = &MockConn{}
+
+	tb := &fakeTB{TB: t}
+	mc := NewMockConn(tb).
+		ExpectWrite([]byte("PING"), 4, nil).
+		ExpectRead([]byte("PONG"), nil).
+		ExpectClose(nil)
+
+	mc.Write([]byte("PING"))
+	mc.Close()
+	if !strings.Contains(tb.failure(), "unexpected call to Close") {
+		t.Fatalf("expected an out of order Close, have '%s'", tb.failure())
+	}
+
+	tb.cleanup()
+	if !strings.Contains(tb.failure(), "2 expected calls weren't made") {
+		t.Fatalf("expected the unmade calls to be reported, have '%s'", tb.failure())
+	}
+}
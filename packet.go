@@ -0,0 +1,267 @@
+package testio
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// PacketNetwork is an in-memory datagram network connecting any
+// number of PacketConns, each with its own address. Like UDP, it
+// preserves message boundaries but makes no promises about delivery:
+// packets may be lost, duplicated, reordered or truncated, with
+// probabilities set on the network. All of these decisions are made
+// by a random source seeded when the network is created, so a given
+// seed and sequence of writes always produces the same result.
+type PacketNetwork struct {
+	mu    sync.Mutex
+	conns map[string]*PacketConn
+	prng  *rand.Rand
+
+	loss, duplicate, reorder, truncate float64
+}
+
+// NewPacketNetwork creates a new PacketNetwork whose faults are
+// driven by seed. By default, every packet is delivered intact and in
+// order.
+func NewPacketNetwork(seed int64) *PacketNetwork {
+	return &PacketNetwork{
+		conns: map[string]*PacketConn{},
+		prng:  rand.New(rand.NewSource(seed)),
+	}
+}
+
+// SetLoss sets the probability that a packet is lost.
+func (pn *PacketNetwork) SetLoss(p float64) {
+	pn.mu.Lock()
+	pn.loss = p
+	pn.mu.Unlock()
+}
+
+// SetDuplication sets the probability that a packet is delivered
+// twice.
+func (pn *PacketNetwork) SetDuplication(p float64) {
+	pn.mu.Lock()
+	pn.duplicate = p
+	pn.mu.Unlock()
+}
+
+// SetReordering sets the probability that a packet overtakes the
+// packet queued ahead of it.
+func (pn *PacketNetwork) SetReordering(p float64) {
+	pn.mu.Lock()
+	pn.reorder = p
+	pn.mu.Unlock()
+}
+
+// SetTruncation sets the probability that a packet is cut short to a
+// random length.
+func (pn *PacketNetwork) SetTruncation(p float64) {
+	pn.mu.Lock()
+	pn.truncate = p
+	pn.mu.Unlock()
+}
+
+// ListenPacket creates a new PacketConn on the network with the given
+// address. It is an error to listen on an address already in use.
+func (pn *PacketNetwork) ListenPacket(addr string) (*PacketConn, error) {
+	pn.mu.Lock()
+	defer pn.mu.Unlock()
+
+	if _, ok := pn.conns[addr]; ok {
+		return nil, &net.OpError{
+			Op:   "listen",
+			Net:  Addr(addr).Network(),
+			Addr: Addr(addr),
+			Err:  os.NewSyscallError("bind", syscall.EADDRINUSE),
+		}
+	}
+
+	pc := &PacketConn{pn: pn, addr: Addr(addr)}
+	pc.cond = sync.NewCond(&pc.mu)
+	pn.conns[addr] = pc
+	return pc, nil
+}
+
+// send passes a packet through the network's faults and queues the
+// result at its destination, if there is one.
+func (pn *PacketNetwork) send(from Addr, to string, p []byte) {
+	pn.mu.Lock()
+	defer pn.mu.Unlock()
+
+	dest, ok := pn.conns[to]
+	if !ok || pn.prng.Float64() < pn.loss {
+		return
+	}
+
+	data := append([]byte(nil), p...)
+	if len(data) > 0 && pn.prng.Float64() < pn.truncate {
+		data = data[:pn.prng.Intn(len(data))]
+	}
+
+	copies := 1
+	if pn.prng.Float64() < pn.duplicate {
+		copies = 2
+	}
+	reorder := pn.prng.Float64() < pn.reorder
+
+	for i := 0; i < copies; i++ {
+		dest.enqueue(packet{data: data, from: from}, reorder && i == 0)
+	}
+}
+
+func (pn *PacketNetwork) remove(pc *PacketConn) {
+	pn.mu.Lock()
+	if pn.conns[pc.addr.String()] == pc {
+		delete(pn.conns, pc.addr.String())
+	}
+	pn.mu.Unlock()
+}
+
+type packet struct {
+	data []byte
+	from Addr
+}
+
+// PacketConn is an in-memory net.PacketConn attached to a
+// PacketNetwork. It is safe for concurrent use.
+type PacketConn struct {
+	pn   *PacketNetwork
+	addr Addr
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	queue  []packet
+	closed bool
+
+	rdeadline, wdeadline deadline
+}
+
+func (pc *PacketConn) opError(op string, err error) error {
+	return &net.OpError{
+		Op:     op,
+		Net:    pc.addr.Network(),
+		Source: pc.addr,
+		Err:    err,
+	}
+}
+
+func (pc *PacketConn) wake() {
+	pc.mu.Lock()
+	pc.cond.Broadcast()
+	pc.mu.Unlock()
+}
+
+// enqueue adds a packet to the receive queue. If overtake is true,
+// the packet is placed ahead of the last packet already queued.
+func (pc *PacketConn) enqueue(pkt packet, overtake bool) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	if pc.closed {
+		return
+	}
+
+	pc.queue = append(pc.queue, pkt)
+	if n := len(pc.queue); overtake && n > 1 {
+		pc.queue[n-2], pc.queue[n-1] = pc.queue[n-1], pc.queue[n-2]
+	}
+	pc.cond.Broadcast()
+}
+
+// ReadFrom reads the next packet into p, returning the number of
+// bytes copied and the address of the sender. As with UDP, if the
+// packet is larger than p, the excess is discarded.
+func (pc *PacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	for {
+		switch {
+		case pc.closed:
+			return 0, nil, pc.opError("read", net.ErrClosed)
+		case pc.rdeadline.expired():
+			return 0, nil, pc.opError("read", os.ErrDeadlineExceeded)
+		case len(pc.queue) > 0:
+			pkt := pc.queue[0]
+			pc.queue = pc.queue[1:]
+			return copy(p, pkt.data), pkt.from, nil
+		}
+		pc.cond.Wait()
+	}
+}
+
+// WriteTo sends p as a single packet to addr. As with UDP, a packet
+// sent to an address with no listener is silently dropped.
+func (pc *PacketConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	if addr == nil {
+		return 0, pc.opError("write", errors.New("testio: missing address"))
+	}
+
+	pc.mu.Lock()
+	switch {
+	case pc.closed:
+		pc.mu.Unlock()
+		return 0, pc.opError("write", net.ErrClosed)
+	case pc.wdeadline.expired():
+		pc.mu.Unlock()
+		return 0, pc.opError("write", os.ErrDeadlineExceeded)
+	}
+	pc.mu.Unlock()
+
+	pc.pn.send(pc.addr, addr.String(), p)
+	return len(p), nil
+}
+
+// Close closes the connection, unblocking any pending reads and
+// freeing its address.
+func (pc *PacketConn) Close() error {
+	pc.mu.Lock()
+	if pc.closed {
+		pc.mu.Unlock()
+		return pc.opError("close", net.ErrClosed)
+	}
+	pc.closed = true
+	pc.queue = nil
+	pc.cond.Broadcast()
+	pc.mu.Unlock()
+
+	pc.pn.remove(pc)
+	return nil
+}
+
+// LocalAddr returns the connection's address.
+func (pc *PacketConn) LocalAddr() net.Addr {
+	return pc.addr
+}
+
+// SetDeadline sets the read and write deadlines for the connection.
+func (pc *PacketConn) SetDeadline(t time.Time) error {
+	pc.SetReadDeadline(t)
+	return pc.SetWriteDeadline(t)
+}
+
+// SetReadDeadline sets the deadline for reads from the connection.
+func (pc *PacketConn) SetReadDeadline(t time.Time) error {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	pc.rdeadline.set(t, pc.wake)
+	pc.cond.Broadcast()
+	return nil
+}
+
+// SetWriteDeadline sets the deadline for writes to the connection.
+// Writes never block, so this only has an effect once the deadline
+// has passed.
+func (pc *PacketConn) SetWriteDeadline(t time.Time) error {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	pc.wdeadline.set(t, pc.wake)
+	return nil
+}
@@ -0,0 +1,113 @@
+package testio
+
+import (
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+var _ net.PacketConn = &PacketConn{}
+
+func TestPacketConn(t *testing.T) {
+	pn := NewPacketNetwork(1)
+	a, err := pn.ListenPacket("a:53")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	b, _ := pn.ListenPacket("b:53")
+	c, _ := pn.ListenPacket("c:53")
+
+	if _, err = pn.ListenPacket("a:53"); err == nil {
+		t.Fatal("expected listening on a used address to fail")
+	}
+
+	a.WriteTo([]byte("to b"), Addr("b:53"))
+	a.WriteTo([]byte("to c"), Addr("c:53"))
+	a.WriteTo([]byte("nobody"), Addr("d:53"))
+	a.WriteTo([]byte("again"), Addr("b:53"))
+
+	if _, err = a.WriteTo([]byte("lost"), nil); err == nil {
+		t.Fatal("expected writing to a nil address to fail")
+	}
+
+	p := make([]byte, 16)
+	n, from, err := b.ReadFrom(p)
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if string(p[:n]) != "to b" {
+		t.Fatalf("expected 'to b', have '%s'", p[:n])
+	} else if from.String() != "a:53" {
+		t.Fatalf("expected the packet to be from a:53, have %s", from)
+	}
+
+	// A short buffer truncates the packet without affecting the next.
+	n, _, err = b.ReadFrom(p[:2])
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if string(p[:n]) != "ag" {
+		t.Fatalf("expected 'ag', have '%s'", p[:n])
+	}
+
+	n, _, err = c.ReadFrom(p)
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if string(p[:n]) != "to c" {
+		t.Fatalf("expected 'to c', have '%s'", p[:n])
+	}
+
+	b.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	_, _, err = b.ReadFrom(p)
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected a timeout, have %v", err)
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		_, _, err := c.ReadFrom(p)
+		errs <- err
+	}()
+	c.Close()
+	if err = <-errs; !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("expected Close to unblock ReadFrom, have %v", err)
+	}
+}
+
+func TestPacketNetworkFaults(t *testing.T) {
+	pn := NewPacketNetwork(1)
+	a, _ := pn.ListenPacket("a")
+	b, _ := pn.ListenPacket("b")
+	b.SetReadDeadline(time.Now().Add(time.Second))
+
+	pn.SetLoss(1)
+	a.WriteTo([]byte("lost"), b.LocalAddr())
+	pn.SetLoss(0)
+
+	pn.SetDuplication(1)
+	a.WriteTo([]byte("twice"), b.LocalAddr())
+	pn.SetDuplication(0)
+
+	pn.SetReordering(1)
+	a.WriteTo([]byte("overtaken"), b.LocalAddr())
+	pn.SetReordering(0)
+
+	p := make([]byte, 16)
+	for _, expected := range []string{"twice", "overtaken", "twice"} {
+		n, _, err := b.ReadFrom(p)
+		if err != nil {
+			t.Fatalf("%v", err)
+		} else if string(p[:n]) != expected {
+			t.Fatalf("expected '%s', have '%s'", expected, p[:n])
+		}
+	}
+
+	pn.SetTruncation(1)
+	a.WriteTo([]byte("truncated"), b.LocalAddr())
+	n, _, err := b.ReadFrom(p)
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if n >= len("truncated") {
+		t.Fatalf("expected the packet to be truncated, have '%s'", p[:n])
+	}
+}
@@ -0,0 +1,376 @@
+package testio
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Addr is a net.Addr for in-memory connections. Its network is always
+// "testio".
+type Addr string
+
+// Network returns the name of the network, "testio".
+func (a Addr) Network() string {
+	return "testio"
+}
+
+// String returns the address.
+func (a Addr) String() string {
+	return string(a)
+}
+
+// deadline tracks a read or write deadline. When the deadline passes,
+// wake is called so that any waiters can notice.
+type deadline struct {
+	t     time.Time
+	timer *time.Timer
+}
+
+// set changes the deadline. The caller should wake any waiters itself
+// after calling set, as the new deadline may already have passed.
+func (d *deadline) set(t time.Time, wake func()) {
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+	}
+
+	d.t = t
+	if t.IsZero() {
+		return
+	}
+
+	if dur := time.Until(t); dur > 0 {
+		d.timer = time.AfterFunc(dur, wake)
+	}
+}
+
+// expired returns true if the deadline has passed.
+func (d *deadline) expired() bool {
+	return !d.t.IsZero() && !time.Now().Before(d.t)
+}
+
+// A pipe is a one-way, in-memory byte stream with an unbounded
+// buffer. It is the building block for BufferConn: each connection is
+// made up of a pipe in each direction.
+type pipe struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	buf  bytes.Buffer
+
+	// block controls whether reads from an empty pipe wait for
+	// data or return io.EOF straight away.
+	block bool
+
+	// rclosed is set when the reading end has been closed, and
+	// wclosed is set when the writing end has been closed.
+	rclosed, wclosed bool
+
+	// rshut and wshut are set when the reading or writing end has
+	// been shut down with a half-close.
+	rshut, wshut bool
+
+	rdeadline, wdeadline deadline
+
+	// rfault and wfault are the faults currently affecting the
+	// reading and writing ends. If rafter or wafter isn't negative,
+	// rpending or wpending takes effect once that many more bytes
+	// have been read or written.
+	rfault, wfault     ConnFault
+	rpending, wpending ConnFault
+	rafter, wafter     int
+
+	// rstallErr and wstallErr, if set, end any stall on the reading
+	// and writing ends: calls that would stall fail with them
+	// instead. rstalled and wstalled count the reads and writes
+	// currently stalled.
+	rstallErr, wstallErr error
+	rstalled, wstalled   int
+}
+
+func newPipe(block bool) *pipe {
+	p := &pipe{block: block, rafter: -1, wafter: -1}
+	p.cond = sync.NewCond(&p.mu)
+	return p
+}
+
+// wake wakes up anything waiting on the pipe.
+func (p *pipe) wake() {
+	p.mu.Lock()
+	p.cond.Broadcast()
+	p.mu.Unlock()
+}
+
+func (p *pipe) read(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for {
+		switch {
+		case p.rclosed:
+			return 0, net.ErrClosed
+		case p.rshut:
+			return 0, io.EOF
+		case p.rfault == FaultReset:
+			return 0, syscall.ECONNRESET
+		case p.rfault == FaultBrokenPipe:
+			return 0, io.EOF
+		case p.rdeadline.expired():
+			return 0, os.ErrDeadlineExceeded
+		case p.rfault == FaultStall && p.rstallErr != nil:
+			return 0, p.rstallErr
+		case p.rfault == FaultStall:
+			p.rstalled++
+			p.cond.Wait()
+			p.rstalled--
+			continue
+		case p.rfault == FaultDrop:
+			p.buf.Reset()
+		}
+
+		switch {
+		case p.buf.Len() > 0:
+			if p.rafter >= 0 && len(b) > p.rafter {
+				b = b[:p.rafter]
+			}
+			n, err := p.buf.Read(b)
+			if p.rafter >= 0 {
+				p.rafter -= n
+				if p.rafter == 0 {
+					p.rfault, p.rafter = p.rpending, -1
+				}
+			}
+			return n, err
+		case len(b) == 0:
+			return 0, nil
+		case p.wclosed || p.wshut || !p.block:
+			return 0, io.EOF
+		}
+		p.cond.Wait()
+	}
+}
+
+func (p *pipe) write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var written int
+	for {
+		switch {
+		case p.wclosed:
+			return written, net.ErrClosed
+		case p.wshut:
+			return written, syscall.EPIPE
+		case p.wfault == FaultReset:
+			return written, syscall.ECONNRESET
+		case p.wfault == FaultBrokenPipe:
+			return written, syscall.EPIPE
+		case p.wdeadline.expired():
+			return written, os.ErrDeadlineExceeded
+		case p.wfault == FaultStall && p.wstallErr != nil:
+			return written, p.wstallErr
+		case p.wfault == FaultStall:
+			p.wstalled++
+			p.cond.Wait()
+			p.wstalled--
+			continue
+		case p.wfault == FaultDrop:
+			return written + len(b), nil
+		case p.rclosed:
+			return written, syscall.EPIPE
+		case p.rshut:
+			// Like TCP, data sent after the reader has shut
+			// down is silently discarded.
+			return written + len(b), nil
+		}
+
+		chunk := b
+		if p.wafter >= 0 && len(chunk) > p.wafter {
+			chunk = chunk[:p.wafter]
+		}
+
+		n, _ := p.buf.Write(chunk)
+		written += n
+		b = b[n:]
+		if p.wafter >= 0 {
+			p.wafter -= n
+			if p.wafter == 0 {
+				p.wfault, p.wafter = p.wpending, -1
+			}
+		}
+		p.cond.Broadcast()
+
+		if len(b) == 0 {
+			return written, nil
+		}
+	}
+}
+
+// expect waits until ready reports that the buffered data is
+// complete, then consumes the number of bytes it returns. ready
+// returns a negative number while it needs more data. If the timeout
+// passes, or no more data can arrive, expect returns false. In either
+// case, it returns the data that was buffered.
+func (p *pipe) expect(timeout time.Duration, ready func([]byte) int) ([]byte, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	end := time.Now().Add(timeout)
+	timer := time.AfterFunc(timeout, p.wake)
+	defer timer.Stop()
+
+	for {
+		data := append([]byte(nil), p.buf.Bytes()...)
+		if n := ready(data); n >= 0 {
+			p.buf.Next(n)
+			return data, true
+		}
+
+		if p.rclosed || p.rshut || p.wclosed || p.wshut || !time.Now().Before(end) {
+			return data, false
+		}
+		p.cond.Wait()
+	}
+}
+
+// closeRead closes the reading end of the pipe, discarding any
+// unread data. It returns false if the reading end was already
+// closed.
+func (p *pipe) closeRead() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.rclosed {
+		return false
+	}
+	p.rclosed = true
+	p.buf.Reset()
+	p.cond.Broadcast()
+	return true
+}
+
+// closeWrite closes the writing end of the pipe; once the remaining
+// data has been read, reads will return io.EOF. It returns false if
+// the writing end was already closed.
+func (p *pipe) closeWrite() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.wclosed {
+		return false
+	}
+	p.wclosed = true
+	p.cond.Broadcast()
+	return true
+}
+
+// shutRead shuts down the reading end of the pipe: unread data is
+// discarded, reads return io.EOF, and further writes are discarded.
+func (p *pipe) shutRead() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.rclosed {
+		return net.ErrClosed
+	}
+	p.rshut = true
+	p.buf.Reset()
+	p.cond.Broadcast()
+	return nil
+}
+
+// shutWrite shuts down the writing end of the pipe: once the
+// remaining data has been read, reads return io.EOF, and further
+// writes fail.
+func (p *pipe) shutWrite() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.wclosed {
+		return net.ErrClosed
+	}
+	p.wshut = true
+	p.cond.Broadcast()
+	return nil
+}
+
+// setReadFault schedules f to affect the reading end of the pipe
+// after another n bytes have been read. If n is zero or negative, it
+// takes effect immediately.
+func (p *pipe) setReadFault(f ConnFault, n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if n <= 0 {
+		p.rfault, p.rafter = f, -1
+	} else {
+		p.rpending, p.rafter = f, n
+	}
+	p.rstallErr = nil
+	p.cond.Broadcast()
+}
+
+// setWriteFault schedules f to affect the writing end of the pipe
+// after another n bytes have been written. If n is zero or negative,
+// it takes effect immediately.
+func (p *pipe) setWriteFault(f ConnFault, n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if n <= 0 {
+		p.wfault, p.wafter = f, -1
+	} else {
+		p.wpending, p.wafter = f, n
+	}
+	p.wstallErr = nil
+	p.cond.Broadcast()
+}
+
+// endReadStall makes stalled reads from the pipe fail with err, as do
+// any later reads that would stall, until the next read fault is set.
+func (p *pipe) endReadStall(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.rstallErr = err
+	p.cond.Broadcast()
+}
+
+// endWriteStall makes stalled writes to the pipe fail with err, as do
+// any later writes that would stall, until the next write fault is
+// set.
+func (p *pipe) endWriteStall(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.wstallErr = err
+	p.cond.Broadcast()
+}
+
+// stalled returns the number of reads and writes currently stalled.
+func (p *pipe) stalled() (readers, writers int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.rstalled, p.wstalled
+}
+
+func (p *pipe) setReadDeadline(t time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.rdeadline.set(t, p.wake)
+	p.cond.Broadcast()
+}
+
+func (p *pipe) setWriteDeadline(t time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.wdeadline.set(t, p.wake)
+	p.cond.Broadcast()
+}
@@ -0,0 +1,501 @@
+package testio
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// An Op identifies the operation in a Call.
+type Op int
+
+const (
+	// OpRead is a call to Read.
+	OpRead Op = iota
+
+	// OpWrite is a call to Write.
+	OpWrite
+
+	// OpClose is a call to Close.
+	OpClose
+
+	// OpSeek is a call to Seek.
+	OpSeek
+
+	// OpSetDeadline is a call to SetDeadline.
+	OpSetDeadline
+
+	// OpSetReadDeadline is a call to SetReadDeadline.
+	OpSetReadDeadline
+
+	// OpSetWriteDeadline is a call to SetWriteDeadline.
+	OpSetWriteDeadline
+)
+
+var opNames = map[Op]string{
+	OpRead:             "READ",
+	OpWrite:            "WRITE",
+	OpClose:            "CLOSE",
+	OpSeek:             "SEEK",
+	OpSetDeadline:      "SETDEADLINE",
+	OpSetReadDeadline:  "SETREADDEADLINE",
+	OpSetWriteDeadline: "SETWRITEDEADLINE",
+}
+
+// String returns the name of the operation, as used in logs.
+func (op Op) String() string {
+	if name, ok := opNames[op]; ok {
+		return name
+	}
+	return fmt.Sprintf("OP(%d)", int(op))
+}
+
+// A Call describes an operation passing through an Interceptor. The
+// policies may change it before and after the operation is carried
+// out.
+type Call struct {
+	Op Op
+
+	// Buf is the buffer for a read or write. Before may shorten
+	// it to limit how much the operation handles. For a read,
+	// After may change Buf[:N], which is what the caller receives.
+	Buf []byte
+
+	// Len is the length of the caller's buffer.
+	Len int
+
+	// N is the number of bytes read or written.
+	N int
+
+	// Offset and Whence are the arguments to Seek. After the
+	// operation, Offset is the new offset.
+	Offset int64
+	Whence int
+
+	// Deadline is the argument to the deadline methods.
+	Deadline time.Time
+
+	// Err is the error from the operation.
+	Err error
+}
+
+// A Policy is consulted by an Interceptor before and after every
+// operation. Before may change the call, or return an error to fail
+// it without the operation being carried out. After may change the
+// outcome of the call. Policies used with an Interceptor that is
+// shared between goroutines must be safe for concurrent use.
+type Policy interface {
+	Before(c *Call) error
+	After(c *Call)
+}
+
+// BeforeFunc is a Policy that only acts before an operation.
+type BeforeFunc func(c *Call) error
+
+// Before calls f.
+func (f BeforeFunc) Before(c *Call) error {
+	return f(c)
+}
+
+// After does nothing.
+func (f BeforeFunc) After(c *Call) {}
+
+// AfterFunc is a Policy that only acts after an operation.
+type AfterFunc func(c *Call)
+
+// Before does nothing.
+func (f AfterFunc) Before(c *Call) error {
+	return nil
+}
+
+// After calls f.
+func (f AfterFunc) After(c *Call) {
+	f(c)
+}
+
+// Interceptor wraps a reader, writer, closer, seeker or net.Conn,
+// passing every operation through a stack of policies. Before is
+// called on each policy in order, then the operation is carried out,
+// then After is called on each policy in reverse order, so the first
+// policy sees the final outcome. If a policy's Before fails the call,
+// only the policies before it, and the failing policy itself, have
+// their After called.
+//
+// Operations that the target doesn't support fail with an error. An
+// Interceptor implements net.Conn and io.Seeker, and is safe for
+// concurrent use if its target and policies are.
+type Interceptor struct {
+	target interface{}
+
+	mu       sync.Mutex
+	policies []Policy
+}
+
+// NewInterceptor wraps target with the given policies.
+func NewInterceptor(target interface{}, policies ...Policy) *Interceptor {
+	return &Interceptor{target: target, policies: policies}
+}
+
+// SetPolicies replaces the interceptor's policies. Operations already
+// in progress continue with the old policies.
+func (ic *Interceptor) SetPolicies(policies ...Policy) {
+	ic.mu.Lock()
+	ic.policies = policies
+	ic.mu.Unlock()
+}
+
+// do runs c through the policies, calling op to carry it out.
+func (ic *Interceptor) do(c *Call, op func(c *Call)) {
+	ic.mu.Lock()
+	policies := ic.policies
+	ic.mu.Unlock()
+
+	ran := len(policies)
+	for i, policy := range policies {
+		if err := policy.Before(c); err != nil {
+			c.Err = err
+			ran = i + 1
+			break
+		}
+	}
+
+	if c.Err == nil {
+		op(c)
+	}
+
+	for i := ran - 1; i >= 0; i-- {
+		policies[i].After(c)
+	}
+}
+
+func unsupported(op Op) error {
+	return fmt.Errorf("testio: target doesn't support %s", op)
+}
+
+// Read reads from the target.
+func (ic *Interceptor) Read(p []byte) (int, error) {
+	c := &Call{Op: OpRead, Buf: p, Len: len(p)}
+	ic.do(c, func(c *Call) {
+		r, ok := ic.target.(io.Reader)
+		if !ok {
+			c.Err = unsupported(OpRead)
+			return
+		}
+		c.N, c.Err = r.Read(c.Buf)
+	})
+
+	if c.N > len(c.Buf) {
+		c.N = len(c.Buf)
+	}
+	return copy(p, c.Buf[:c.N]), c.Err
+}
+
+// Write writes to the target. If the policies limit how much can be
+// written at once, the data is written in several calls, each of
+// which goes through the policies.
+func (ic *Interceptor) Write(p []byte) (int, error) {
+	var written int
+	for {
+		c := &Call{Op: OpWrite, Buf: p, Len: len(p)}
+		ic.do(c, func(c *Call) {
+			w, ok := ic.target.(io.Writer)
+			if !ok {
+				c.Err = unsupported(OpWrite)
+				return
+			}
+			c.N, c.Err = w.Write(c.Buf)
+		})
+
+		written += c.N
+		if c.Err != nil {
+			return written, c.Err
+		}
+
+		p = p[c.N:]
+		if len(p) == 0 {
+			return written, nil
+		} else if c.N == 0 {
+			return written, io.ErrShortWrite
+		}
+	}
+}
+
+// Close closes the target.
+func (ic *Interceptor) Close() error {
+	c := &Call{Op: OpClose}
+	ic.do(c, func(c *Call) {
+		cl, ok := ic.target.(io.Closer)
+		if !ok {
+			c.Err = unsupported(OpClose)
+			return
+		}
+		c.Err = cl.Close()
+	})
+	return c.Err
+}
+
+// Seek seeks the target.
+func (ic *Interceptor) Seek(offset int64, whence int) (int64, error) {
+	c := &Call{Op: OpSeek, Offset: offset, Whence: whence}
+	ic.do(c, func(c *Call) {
+		s, ok := ic.target.(io.Seeker)
+		if !ok {
+			c.Err = unsupported(OpSeek)
+			return
+		}
+		c.Offset, c.Err = s.Seek(c.Offset, c.Whence)
+	})
+	return c.Offset, c.Err
+}
+
+// deadliner is implemented by types with net.Conn's deadline methods.
+type deadliner interface {
+	SetDeadline(t time.Time) error
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+}
+
+func (ic *Interceptor) setDeadline(op Op, t time.Time) error {
+	c := &Call{Op: op, Deadline: t}
+	ic.do(c, func(c *Call) {
+		d, ok := ic.target.(deadliner)
+		if !ok {
+			c.Err = unsupported(op)
+			return
+		}
+
+		switch op {
+		case OpSetDeadline:
+			c.Err = d.SetDeadline(c.Deadline)
+		case OpSetReadDeadline:
+			c.Err = d.SetReadDeadline(c.Deadline)
+		case OpSetWriteDeadline:
+			c.Err = d.SetWriteDeadline(c.Deadline)
+		}
+	})
+	return c.Err
+}
+
+// SetDeadline sets the target's deadlines.
+func (ic *Interceptor) SetDeadline(t time.Time) error {
+	return ic.setDeadline(OpSetDeadline, t)
+}
+
+// SetReadDeadline sets the target's read deadline.
+func (ic *Interceptor) SetReadDeadline(t time.Time) error {
+	return ic.setDeadline(OpSetReadDeadline, t)
+}
+
+// SetWriteDeadline sets the target's write deadline.
+func (ic *Interceptor) SetWriteDeadline(t time.Time) error {
+	return ic.setDeadline(OpSetWriteDeadline, t)
+}
+
+// LocalAddr returns the target's local address, if it is a net.Conn.
+func (ic *Interceptor) LocalAddr() net.Addr {
+	if conn, ok := ic.target.(net.Conn); ok {
+		return conn.LocalAddr()
+	}
+	return nil
+}
+
+// RemoteAddr returns the target's remote address, if it is a
+// net.Conn.
+func (ic *Interceptor) RemoteAddr() net.Addr {
+	if conn, ok := ic.target.(net.Conn); ok {
+		return conn.RemoteAddr()
+	}
+	return nil
+}
+
+// limitPolicy fails reads and writes once a number of bytes have been
+// read or written.
+type limitPolicy struct {
+	mu               sync.Mutex
+	rcurrent, rlimit int
+	wcurrent, wlimit int
+}
+
+// NewLimitPolicy returns a policy that fails writes once wlimit bytes
+// have been written and reads once rlimit bytes have been read, like
+// BrokenWriter and BrokenReader. A negative limit means no limit.
+func NewLimitPolicy(wlimit, rlimit int) Policy {
+	return &limitPolicy{wlimit: wlimit, rlimit: rlimit}
+}
+
+func (lp *limitPolicy) Before(c *Call) error {
+	lp.mu.Lock()
+	defer lp.mu.Unlock()
+
+	switch c.Op {
+	case OpRead:
+		if lp.rlimit < 0 {
+			return nil
+		}
+
+		remain := lp.rlimit - lp.rcurrent
+		if remain <= 0 {
+			return errors.New("testio: read failed")
+		} else if len(c.Buf) > remain {
+			c.Buf = c.Buf[:remain]
+		}
+	case OpWrite:
+		if lp.wlimit < 0 {
+			return nil
+		}
+
+		remain := lp.wlimit - lp.wcurrent
+		if remain <= 0 {
+			return errors.New("testio: write failed")
+		} else if len(c.Buf) > remain {
+			c.Buf = c.Buf[:remain]
+		}
+	}
+	return nil
+}
+
+func (lp *limitPolicy) After(c *Call) {
+	lp.mu.Lock()
+	defer lp.mu.Unlock()
+
+	switch c.Op {
+	case OpRead:
+		lp.rcurrent += c.N
+		if lp.rlimit >= 0 && lp.rcurrent >= lp.rlimit && c.Err == nil {
+			c.Err = errors.New("testio: read failed")
+		}
+	case OpWrite:
+		lp.wcurrent += c.N
+		if lp.wlimit >= 0 && lp.wcurrent >= lp.wlimit && c.N < c.Len && c.Err == nil {
+			c.Err = errors.New("testio: write failed")
+		}
+	}
+}
+
+// logPolicy logs reads and writes in the same format as a
+// LoggingBuffer.
+type logPolicy struct {
+	mu   sync.Mutex
+	w    io.Writer
+	name string
+}
+
+// NewLogPolicy returns a policy that logs the data of every read and
+// write to w, in the same format as a LoggingBuffer. Other operations
+// are logged by name. If name isn't empty, each line is prefixed with
+// it.
+func NewLogPolicy(w io.Writer, name string) Policy {
+	return &logPolicy{w: w, name: name}
+}
+
+func (lp *logPolicy) Before(c *Call) error {
+	return nil
+}
+
+func (lp *logPolicy) After(c *Call) {
+	if c.Op == OpRead && c.N == 0 && c.Err == io.EOF {
+		return
+	}
+
+	lp.mu.Lock()
+	defer lp.mu.Unlock()
+
+	if lp.name != "" {
+		fmt.Fprintf(lp.w, "[%s] ", lp.name)
+	}
+
+	switch c.Op {
+	case OpRead, OpWrite:
+		// A later policy may have set N beyond the buffer; the
+		// Interceptor clamps it once every policy has run.
+		n := c.N
+		if n > len(c.Buf) {
+			n = len(c.Buf)
+		}
+		fmt.Fprintf(lp.w, "[%s] %x", c.Op, c.Buf[:n])
+	case OpSeek:
+		fmt.Fprintf(lp.w, "[%s] %d", c.Op, c.Offset)
+	default:
+		fmt.Fprintf(lp.w, "[%s]", c.Op)
+	}
+
+	if c.Err != nil && c.Err != io.EOF {
+		fmt.Fprintf(lp.w, " error: %v", c.Err)
+	}
+	fmt.Fprintln(lp.w)
+}
+
+// NewChunkPolicy returns a policy that limits each read and write to
+// at most size bytes, like ChunkedReader and ChunkedWriter.
+func NewChunkPolicy(size int) Policy {
+	if size < 1 {
+		size = 1
+	}
+
+	return BeforeFunc(func(c *Call) error {
+		if (c.Op == OpRead || c.Op == OpWrite) && len(c.Buf) > size {
+			c.Buf = c.Buf[:size]
+		}
+		return nil
+	})
+}
+
+// NewLatencyPolicy returns a policy that delays every read and write
+// by d.
+func NewLatencyPolicy(d time.Duration) Policy {
+	return BeforeFunc(func(c *Call) error {
+		if c.Op == OpRead || c.Op == OpWrite {
+			time.Sleep(d)
+		}
+		return nil
+	})
+}
+
+// NewThrottlePolicy returns a policy that limits reads and writes to
+// about bytesPerSecond, by sleeping after each one for as long as the
+// data would have taken to transfer.
+func NewThrottlePolicy(bytesPerSecond int) Policy {
+	return AfterFunc(func(c *Call) {
+		if bytesPerSecond <= 0 || (c.Op != OpRead && c.Op != OpWrite) {
+			return
+		}
+		time.Sleep(time.Duration(c.N) * time.Second / time.Duration(bytesPerSecond))
+	})
+}
+
+// chaosPolicy fails reads and writes at random.
+type chaosPolicy struct {
+	mu   sync.Mutex
+	prng *rand.Rand
+	rate float64
+}
+
+// NewChaosPolicy returns a policy that fails each read and write with
+// probability rate. The failures are chosen by a random source seeded
+// with seed, so they are reproducible.
+func NewChaosPolicy(seed int64, rate float64) Policy {
+	return &chaosPolicy{
+		prng: rand.New(rand.NewSource(seed)),
+		rate: rate,
+	}
+}
+
+func (cp *chaosPolicy) Before(c *Call) error {
+	if c.Op != OpRead && c.Op != OpWrite {
+		return nil
+	}
+
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	if cp.prng.Float64() < cp.rate {
+		return fmt.Errorf("testio: injected %s failure", c.Op)
+	}
+	return nil
+}
+
+func (cp *chaosPolicy) After(c *Call) {}
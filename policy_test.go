@@ -0,0 +1,107 @@
+package testio
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestInterceptor(t *testing.T) {
+	buf := &bytes.Buffer{}
+	log := &bytes.Buffer{}
+	ic := NewInterceptor(buf, NewLogPolicy(log, "TEST"), NewChunkPolicy(2),
+		NewLimitPolicy(5, -1))
+
+	n, err := ic.Write([]byte("ABCDEF"))
+	if err == nil {
+		t.Fatal("expected a write failure")
+	} else if n != 5 {
+		t.Fatalf("expected write size of 5, have %d", n)
+	} else if buf.String() != "ABCDE" {
+		t.Fatalf("expected 'ABCDE', have '%s'", buf.String())
+	}
+
+	expected := "[TEST] [WRITE] 4142\n[TEST] [WRITE] 4344\n" +
+		"[TEST] [WRITE] 45 error: testio: write failed\n"
+	if log.String() != expected {
+		t.Fatalf("expected log\n%s\nhave\n%s", expected, log.String())
+	}
+
+	// A policy can rewrite the data returned by a read.
+	upper := AfterFunc(func(c *Call) {
+		if c.Op == OpRead {
+			copy(c.Buf, bytes.ToUpper(c.Buf[:c.N]))
+		}
+	})
+	ic = NewInterceptor(strings.NewReader("abc"), upper)
+	data, err := io.ReadAll(ic)
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if string(data) != "ABC" {
+		t.Fatalf("expected 'ABC', have '%s'", data)
+	}
+
+	// The log policy copes with a policy overstating N.
+	log.Reset()
+	overstate := AfterFunc(func(c *Call) {
+		if c.Op == OpRead {
+			c.N = len(c.Buf) + 10
+		}
+	})
+	ic = NewInterceptor(strings.NewReader("abc"), NewLogPolicy(log, ""), overstate)
+	p := make([]byte, 2)
+	if n, _ := ic.Read(p); n != 2 {
+		t.Fatalf("expected N to be clamped to 2, have %d", n)
+	} else if log.String() != "[READ] 6162\n" {
+		t.Fatalf("expected '[READ] 6162', have '%s'", log.String())
+	}
+
+	// A policy can veto an operation.
+	closed := errors.New("closed by policy")
+	ic.SetPolicies(BeforeFunc(func(c *Call) error {
+		if c.Op == OpSeek {
+			return closed
+		}
+		return nil
+	}))
+
+	if _, err = ic.Seek(0, io.SeekStart); err != closed {
+		t.Fatalf("expected the policy's error, have %v", err)
+	}
+
+	if err = ic.Close(); err == nil {
+		t.Fatal("expected Close on a reader to be unsupported")
+	}
+}
+
+func TestInterceptorConn(t *testing.T) {
+	c1, c2 := NewBufferConnPair()
+	var conn net.Conn = NewInterceptor(c1, NewChaosPolicy(1, 1))
+
+	_, err := conn.Write([]byte("A"))
+	if err == nil {
+		t.Fatal("expected an injected failure")
+	}
+
+	if conn.RemoteAddr() != c1.RemoteAddr() {
+		t.Fatalf("expected the remote address %s, have %s",
+			c1.RemoteAddr(), conn.RemoteAddr())
+	}
+
+	conn = NewInterceptor(c1, NewChaosPolicy(1, 0), NewLimitPolicy(-1, 1))
+	c2.Write([]byte("XY"))
+	p := make([]byte, 2)
+	n, err := conn.Read(p)
+	if err == nil {
+		t.Fatal("expected a read failure")
+	} else if n != 1 {
+		t.Fatalf("expected read size of 1, have %d", n)
+	}
+
+	if err = conn.Close(); err != nil {
+		t.Fatalf("%v", err)
+	}
+}
@@ -0,0 +1,386 @@
+package testio
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProxyConfig controls the faults a Proxy injects. Each setting
+// applies to both directions of a connection separately. The zero
+// value forwards data unchanged.
+//
+// In JSON, the fields are named in snake case, and the latency is
+// given as a string parsed by time.ParseDuration:
+//
+//	{"latency": "50ms", "bytes_per_second": 65536, "reset_rate": 0.01}
+type ProxyConfig struct {
+	// Latency delays each chunk of data forwarded.
+	Latency time.Duration `json:"latency"`
+
+	// BytesPerSecond, if positive, limits the bandwidth.
+	BytesPerSecond int `json:"bytes_per_second"`
+
+	// ResetRate is the probability that forwarding a chunk of data
+	// fails, resetting the connection.
+	ResetRate float64 `json:"reset_rate"`
+
+	// ResetAfter, if positive, resets the connection once that
+	// many bytes have been forwarded.
+	ResetAfter int `json:"reset_after"`
+
+	// CorruptRate is the probability that a bit of each byte
+	// forwarded is flipped.
+	CorruptRate float64 `json:"corrupt_rate"`
+
+	// Log logs the data forwarded, before corruption, in the same
+	// format as a LoggingBuffer.
+	Log bool `json:"log"`
+
+	// Seed seeds the random choice of resets and corruption. Each
+	// connection uses a different seed derived from it, so that a
+	// run can be reproduced.
+	Seed int64 `json:"seed"`
+}
+
+// UnmarshalJSON decodes a ProxyConfig, parsing the latency as a
+// duration.
+func (cfg *ProxyConfig) UnmarshalJSON(data []byte) error {
+	type config ProxyConfig
+	aux := struct {
+		*config
+		Latency string `json:"latency"`
+	}{config: (*config)(cfg)}
+
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	if aux.Latency != "" {
+		latency, err := time.ParseDuration(aux.Latency)
+		if err != nil {
+			return fmt.Errorf("testio: invalid latency: %v", err)
+		}
+		cfg.Latency = latency
+	}
+	return nil
+}
+
+// MarshalJSON encodes a ProxyConfig in the form read by UnmarshalJSON.
+func (cfg ProxyConfig) MarshalJSON() ([]byte, error) {
+	type config ProxyConfig
+	return json.Marshal(struct {
+		config
+		Latency string `json:"latency"`
+	}{config(cfg), cfg.Latency.String()})
+}
+
+// LoadProxyConfig reads a JSON ProxyConfig from the named file. YAML
+// isn't supported, as it would need a dependency outside the
+// standard library; files ending in .yaml or .yml are rejected.
+func LoadProxyConfig(path string) (ProxyConfig, error) {
+	var cfg ProxyConfig
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return cfg, fmt.Errorf("testio: %s: YAML configuration isn't supported, use JSON", path)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+
+	err = json.Unmarshal(data, &cfg)
+	return cfg, err
+}
+
+// policies returns the policies for one direction of a connection.
+func (cfg ProxyConfig) policies(seed int64, log io.Writer, name string) []Policy {
+	var policies []Policy
+	if cfg.Log {
+		policies = append(policies, NewLogPolicy(log, name))
+	}
+	if cfg.ResetAfter > 0 {
+		policies = append(policies, NewLimitPolicy(cfg.ResetAfter, -1))
+	}
+	if cfg.ResetRate > 0 {
+		policies = append(policies, NewChaosPolicy(seed, cfg.ResetRate))
+	}
+	if cfg.Latency > 0 {
+		policies = append(policies, NewLatencyPolicy(cfg.Latency))
+	}
+	if cfg.BytesPerSecond > 0 {
+		policies = append(policies, NewThrottlePolicy(cfg.BytesPerSecond))
+	}
+	return policies
+}
+
+// A Proxy is a TCP proxy that forwards connections to a target
+// address, injecting faults as it goes. Unlike the in-memory
+// connections, it can be put between real clients and servers, such
+// as a database driver and a database running in the test process.
+//
+// Its configuration can be changed at any time, and the change
+// applies to live connections as well as new ones.
+type Proxy struct {
+	ln     net.Listener
+	target string
+
+	mu     sync.Mutex
+	cfg    ProxyConfig
+	conns  map[*proxyConn]bool
+	count  int64
+	closed bool
+
+	log *proxyLog
+	wg  sync.WaitGroup
+}
+
+// proxyLog is the writer that a proxy's connections log to, which
+// passes the lines on to a writer that can be changed under them.
+type proxyLog struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (pl *proxyLog) Write(p []byte) (int, error) {
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+
+	return pl.w.Write(p)
+}
+
+// NewProxy listens on the TCP address listen, usually
+// "localhost:0", and starts forwarding the connections it accepts to
+// target. By default, data is logged to standard error.
+func NewProxy(listen, target string, cfg ProxyConfig) (*Proxy, error) {
+	ln, err := net.Listen("tcp", listen)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Proxy{
+		ln:     ln,
+		target: target,
+		cfg:    cfg,
+		log:    &proxyLog{w: os.Stderr},
+		conns:  map[*proxyConn]bool{},
+	}
+
+	p.wg.Add(1)
+	go p.serve()
+	return p, nil
+}
+
+// Addr returns the address the proxy is listening on.
+func (p *Proxy) Addr() net.Addr {
+	return p.ln.Addr()
+}
+
+// LogTo sets the io.Writer that the proxy logs data to when logging
+// is enabled. It takes effect immediately, for live connections as
+// well as new ones.
+func (p *Proxy) LogTo(w io.Writer) {
+	p.log.mu.Lock()
+	p.log.w = w
+	p.log.mu.Unlock()
+}
+
+// Config returns the proxy's configuration.
+func (p *Proxy) Config() ProxyConfig {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.cfg
+}
+
+// SetConfig changes the proxy's configuration, for live connections
+// as well as new ones. The count of bytes forwarded towards
+// ResetAfter starts again for live connections.
+func (p *Proxy) SetConfig(cfg ProxyConfig) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.cfg = cfg
+	for pc := range p.conns {
+		pc.configure(cfg, p.log)
+	}
+}
+
+// Conns returns the number of live connections.
+func (p *Proxy) Conns() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return len(p.conns)
+}
+
+// Reset resets every live connection, as if the network had failed.
+// New connections are still accepted.
+func (p *Proxy) Reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for pc := range p.conns {
+		pc.reset()
+	}
+}
+
+// Close stops the proxy, resets its connections and waits for them
+// to finish.
+func (p *Proxy) Close() error {
+	p.mu.Lock()
+	p.closed = true
+	for pc := range p.conns {
+		pc.reset()
+	}
+	p.mu.Unlock()
+
+	err := p.ln.Close()
+	p.wg.Wait()
+	return err
+}
+
+// serve accepts connections until the proxy is closed.
+func (p *Proxy) serve() {
+	defer p.wg.Done()
+
+	for {
+		client, err := p.ln.Accept()
+		if err != nil {
+			return
+		}
+
+		p.wg.Add(1)
+		go p.handle(client)
+	}
+}
+
+// handle dials the target for a client and forwards data between
+// them.
+func (p *Proxy) handle(client net.Conn) {
+	defer p.wg.Done()
+
+	server, err := net.Dial("tcp", p.target)
+	if err != nil {
+		resetConn(client)
+		return
+	}
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		resetConn(client)
+		resetConn(server)
+		return
+	}
+
+	seed := p.cfg.Seed + 2*p.count
+	p.count++
+	pc := &proxyConn{
+		client: client,
+		server: server,
+		up:     newProxyFlow(server, seed, fmt.Sprintf("%s > %s", client.RemoteAddr(), p.target)),
+		down:   newProxyFlow(client, seed+1, fmt.Sprintf("%s < %s", client.RemoteAddr(), p.target)),
+	}
+	pc.configure(p.cfg, p.log)
+	p.conns[pc] = true
+	p.mu.Unlock()
+
+	pc.run()
+
+	p.mu.Lock()
+	delete(p.conns, pc)
+	p.mu.Unlock()
+}
+
+// A proxyFlow carries one direction of a proxied connection, writing
+// to dst through a Corrupter and an Interceptor.
+type proxyFlow struct {
+	name      string
+	seed      int64
+	corrupter *Corrupter
+	ic        *Interceptor
+}
+
+func newProxyFlow(dst net.Conn, seed int64, name string) *proxyFlow {
+	f := &proxyFlow{name: name, seed: seed, corrupter: NewCorrupter(seed)}
+	f.ic = NewInterceptor(f.corrupter.Writer(dst))
+	return f
+}
+
+// proxyConn is a connection being proxied.
+type proxyConn struct {
+	client, server net.Conn
+	up, down       *proxyFlow
+
+	once sync.Once
+}
+
+// configure applies cfg to both directions of the connection.
+func (pc *proxyConn) configure(cfg ProxyConfig, log io.Writer) {
+	for _, f := range []*proxyFlow{pc.up, pc.down} {
+		f.corrupter.SetRate(cfg.CorruptRate, CorruptFlip)
+		f.ic.SetPolicies(cfg.policies(f.seed, log, f.name)...)
+	}
+}
+
+// run forwards data in both directions until both sides have closed
+// their end, or until forwarding fails, when the connection is reset.
+func (pc *proxyConn) run() {
+	errs := make(chan error, 2)
+	go func() {
+		errs <- forward(pc.up.ic, pc.client, pc.server)
+	}()
+	go func() {
+		errs <- forward(pc.down.ic, pc.server, pc.client)
+	}()
+
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			pc.reset()
+		}
+	}
+
+	pc.once.Do(func() {
+		pc.client.Close()
+		pc.server.Close()
+	})
+}
+
+// reset closes both sides of the connection abruptly.
+func (pc *proxyConn) reset() {
+	pc.once.Do(func() {
+		resetConn(pc.client)
+		resetConn(pc.server)
+	})
+}
+
+// forward copies data from src to w, and closes dst for writing when
+// src reaches EOF.
+func forward(w io.Writer, src, dst net.Conn) error {
+	if _, err := io.Copy(w, src); err != nil {
+		return err
+	}
+
+	if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
+
+// resetConn closes c, sending a TCP reset rather than a FIN if it is
+// a TCP connection.
+func resetConn(c net.Conn) {
+	if tc, ok := c.(*net.TCPConn); ok {
+		tc.SetLinger(0)
+	}
+	c.Close()
+}
@@ -0,0 +1,215 @@
+package testio
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// proxyEcho starts a TCP echo server on the loopback interface and a
+// proxy in front of it, and returns the proxy.
+func proxyEcho(t *testing.T, cfg ProxyConfig) *Proxy {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("unable to listen on loopback: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(c, c)
+				c.Close()
+			}()
+		}
+	}()
+
+	p, err := NewProxy("127.0.0.1:0", ln.Addr().String(), cfg)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	t.Cleanup(func() { p.Close() })
+	return p
+}
+
+// proxyDial dials the proxy, with a deadline so that a broken test
+// doesn't hang.
+func proxyDial(t *testing.T, p *Proxy) net.Conn {
+	c, err := net.Dial("tcp", p.Addr().String())
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	return c
+}
+
+func TestProxy(t *testing.T) {
+	p := proxyEcho(t, ProxyConfig{})
+	c := proxyDial(t, p)
+
+	if err := simRoundTrip(c, "hello"); err != nil {
+		t.Fatalf("%v", err)
+	} else if p.Conns() != 1 {
+		t.Fatalf("expected 1 live connection, have %d", p.Conns())
+	}
+
+	// A new configuration applies to the live connection.
+	p.SetConfig(ProxyConfig{Latency: 20 * time.Millisecond})
+	start := time.Now()
+	if err := simRoundTrip(c, "hello"); err != nil {
+		t.Fatalf("%v", err)
+	} else if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Fatalf("expected a round trip of at least 40ms, have %v", elapsed)
+	}
+}
+
+func TestProxyReset(t *testing.T) {
+	p := proxyEcho(t, ProxyConfig{})
+	c := proxyDial(t, p)
+	if err := simRoundTrip(c, "hello"); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	p.Reset()
+	if _, err := c.Read(make([]byte, 1)); err == nil {
+		t.Fatal("expected the read to fail after a reset")
+	}
+
+	p.SetConfig(ProxyConfig{ResetAfter: 4})
+	c = proxyDial(t, p)
+	if err := simRoundTrip(c, "hello"); err == nil {
+		t.Fatal("expected the connection to be reset after 4 bytes")
+	}
+}
+
+func TestProxyCorrupt(t *testing.T) {
+	p := proxyEcho(t, ProxyConfig{CorruptRate: 1, Log: true})
+	c := proxyDial(t, p)
+	for deadline := time.Now().Add(time.Second); p.Conns() != 1; {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the proxy to accept the connection")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// The log writer can be changed for a live connection.
+	log := NewBufCloser(nil)
+	p.LogTo(log)
+
+	c.Write([]byte("hello"))
+	q := make([]byte, 5)
+	if _, err := io.ReadFull(c, q); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if string(q) == "hello" {
+		t.Fatal("expected the echoed data to be corrupted")
+	}
+
+	// The data is logged as it was before it was corrupted. The line
+	// is written once the forwarded write returns, which may be
+	// after the echo has arrived.
+	for deadline := time.Now().Add(time.Second); ; {
+		if bytes.Contains(log.Bytes(), []byte("[WRITE] 68656c6c6f")) {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatalf("expected the data to be logged, have '%s'", log.Bytes())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestProxyConfigJSON(t *testing.T) {
+	var cfg ProxyConfig
+	err := json.Unmarshal([]byte(`{"latency": "50ms", "reset_rate": 0.5, "log": true}`), &cfg)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	expected := ProxyConfig{Latency: 50 * time.Millisecond, ResetRate: 0.5, Log: true}
+	if cfg != expected {
+		t.Fatalf("expected %+v, have %+v", expected, cfg)
+	}
+
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	cfg = ProxyConfig{}
+	if err = json.Unmarshal(data, &cfg); err != nil {
+		t.Fatalf("%v", err)
+	} else if cfg != expected {
+		t.Fatalf("expected %+v after a round trip, have %+v", expected, cfg)
+	}
+
+	err = json.Unmarshal([]byte(`{"latency": "soon"}`), &cfg)
+	if err == nil || !strings.Contains(err.Error(), "invalid latency") {
+		t.Fatalf("expected an invalid latency to be rejected, have %v", err)
+	}
+}
+
+func TestLoadProxyConfig(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "proxy.json")
+	if err := os.WriteFile(path, []byte(`{"reset_after": 10}`), 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	cfg, err := LoadProxyConfig(path)
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if cfg.ResetAfter != 10 {
+		t.Fatalf("expected a reset after 10 bytes, have %d", cfg.ResetAfter)
+	}
+
+	path = filepath.Join(dir, "proxy.yaml")
+	os.WriteFile(path, []byte("reset_after: 10\n"), 0644)
+	_, err = LoadProxyConfig(path)
+	if err == nil || !strings.Contains(err.Error(), "YAML configuration isn't supported") {
+		t.Fatalf("expected YAML to be rejected, have %v", err)
+	}
+}
+
+func TestConnProxy(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("unable to listen on loopback: %v", err)
+	}
+	defer ln.Close()
+
+	p, err := NewProxy("127.0.0.1:0", ln.Addr().String(), ProxyConfig{})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer p.Close()
+
+	TestConn(t, func() (net.Conn, net.Conn, func(), error) {
+		c1, err := net.Dial("tcp", p.Addr().String())
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		c2, err := ln.Accept()
+		if err != nil {
+			c1.Close()
+			return nil, nil, nil, err
+		}
+
+		stop := func() {
+			c1.Close()
+			c2.Close()
+		}
+		return c1, c2, stop, nil
+	})
+}
@@ -0,0 +1,95 @@
+package testio
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// HandlerTransport is an http.RoundTripper that sends requests to an
+// http.Handler over in-memory connections. Unlike calling the handler
+// directly, requests and responses go through a real http.Server and
+// http.Transport, so the wire format, chunked encoding, keep-alives
+// and connection reuse all behave as they would over a socket. Every
+// request is sent to the handler, whatever its host, and only plain
+// HTTP is supported.
+//
+// Faults may be injected by wrapping the client or server side of
+// each connection with a ConnHook; BrokenConn and SlowConn are useful
+// for this.
+type HandlerTransport struct {
+	ln  *Listener
+	srv *http.Server
+	tr  *http.Transport
+
+	mu    sync.Mutex
+	hook  ConnHook
+	dials int
+}
+
+// NewHandlerTransport starts serving h over in-memory connections and
+// returns a transport that sends requests to it. Close should be
+// called when the transport is no longer needed.
+func NewHandlerTransport(h http.Handler) *HandlerTransport {
+	ht := &HandlerTransport{
+		ln:  NewListener("handler:80", 0),
+		srv: &http.Server{Handler: h},
+	}
+	ht.tr = &http.Transport{DialContext: ht.dial}
+
+	go ht.srv.Serve(ht.ln)
+	return ht
+}
+
+func (ht *HandlerTransport) dial(ctx context.Context, network, address string) (net.Conn, error) {
+	c, err := ht.ln.DialContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ht.mu.Lock()
+	hook := ht.hook
+	n := ht.dials
+	ht.dials++
+	ht.mu.Unlock()
+
+	if hook != nil {
+		c = hook(n, c)
+	}
+	return c, nil
+}
+
+// RoundTrip sends the request to the handler and returns its response.
+func (ht *HandlerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return ht.tr.RoundTrip(req)
+}
+
+// SetClientConnHook sets a hook that is called on the client side of
+// each new connection.
+func (ht *HandlerTransport) SetClientConnHook(hook ConnHook) {
+	ht.mu.Lock()
+	ht.hook = hook
+	ht.mu.Unlock()
+}
+
+// SetServerConnHook sets a hook that is called on the server side of
+// each new connection, before it is handed to the http.Server.
+func (ht *HandlerTransport) SetServerConnHook(hook ConnHook) {
+	ht.ln.SetConnHook(hook)
+}
+
+// Dials returns the number of connections the transport has made.
+// Comparing it to the number of requests shows whether connections
+// are being reused.
+func (ht *HandlerTransport) Dials() int {
+	ht.mu.Lock()
+	defer ht.mu.Unlock()
+	return ht.dials
+}
+
+// Close closes any idle connections and shuts down the server.
+func (ht *HandlerTransport) Close() error {
+	ht.tr.CloseIdleConnections()
+	return ht.srv.Close()
+}
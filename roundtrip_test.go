@@ -0,0 +1,94 @@
+package testio
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandlerTransport(t *testing.T) {
+	body := strings.Repeat("testio", 1000)
+	ht := NewHandlerTransport(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/chunked" {
+			io.WriteString(w, "AB")
+			w.(http.Flusher).Flush()
+			io.WriteString(w, "CD")
+			return
+		}
+		io.WriteString(w, body)
+	}))
+	defer ht.Close()
+
+	client := &http.Client{Transport: ht}
+	for i := 0; i < 3; i++ {
+		resp, err := client.Get("http://example.com/")
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		data, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("%v", err)
+		} else if string(data) != body {
+			t.Fatalf("expected a %d byte body, have %d bytes", len(body), len(data))
+		}
+	}
+
+	if ht.Dials() != 1 {
+		t.Fatalf("expected the connection to be reused, but dialed %d times",
+			ht.Dials())
+	}
+
+	resp, err := client.Get("http://example.com/chunked")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	data, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if string(data) != "ABCD" {
+		t.Fatalf("expected 'ABCD', have '%s'", data)
+	} else if len(resp.TransferEncoding) != 1 || resp.TransferEncoding[0] != "chunked" {
+		t.Fatalf("expected a chunked response, have %v", resp.TransferEncoding)
+	}
+}
+
+func TestHandlerTransportFaults(t *testing.T) {
+	body := bytes.Repeat([]byte("A"), 4096)
+	ht := NewHandlerTransport(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(body)
+	}))
+	defer ht.Close()
+
+	ht.SetServerConnHook(func(n int, c net.Conn) net.Conn {
+		return NewBrokenConn(c, 1024)
+	})
+
+	client := &http.Client{Transport: ht}
+	resp, err := client.Get("http://example.com/")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	_, err = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err == nil {
+		t.Fatal("expected the body to be cut off")
+	}
+
+	ht.SetServerConnHook(func(n int, c net.Conn) net.Conn {
+		return NewSlowConn(c, 100*time.Millisecond)
+	})
+
+	client.Timeout = 10 * time.Millisecond
+	_, err = client.Get("http://example.com/")
+	if err == nil {
+		t.Fatal("expected the request to time out")
+	}
+}
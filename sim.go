@@ -0,0 +1,484 @@
+package testio
+
+import (
+	"context"
+	"hash/fnv"
+	"math/rand"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// firstEphemeralPort is the first port number given to the
+	// client end of a simulated connection.
+	firstEphemeralPort = 49152
+
+	// simRetransmit is how long a chunk lost on a lossy link takes
+	// to be retransmitted, like TCP's minimum retransmission
+	// timeout.
+	simRetransmit = 200 * time.Millisecond
+
+	// simMaxRetransmits limits the number of times a chunk can be
+	// lost, as TCP eventually gives up and sends it anyway.
+	simMaxRetransmits = 15
+)
+
+// simLink holds the state of the link from one node to another.
+type simLink struct {
+	latency, jitter time.Duration
+	loss            float64
+	down            bool
+}
+
+// Simulator is an in-memory network of named nodes, for testing
+// distributed systems without sockets. Nodes listen and dial on
+// "node:port" addresses; every connection is carried by a pair of
+// forwarding goroutines, one in each direction, which apply the
+// latency of the link between the nodes and hold back data while the
+// link is partitioned. Links may be partitioned, in one or both
+// directions, healed, slowed down or made lossy in the middle of a
+// test, and the changes affect live connections as well as new ones.
+//
+// Jitter and loss are drawn from a separate source for each direction
+// of each connection, seeded from the Simulator's seed, the names of
+// the nodes and the number of earlier connections between them. As
+// long as the connections between each pair of nodes are made in the
+// same order, a test that fails can be rerun with the same delays. A
+// Simulator is safe for concurrent use.
+type Simulator struct {
+	mu    sync.Mutex
+	cond  *sync.Cond
+	seed  int64
+	nodes map[string]*Node
+	links map[[2]string]*simLink
+	flows map[*simFlow]bool
+	conns map[[2]string]int
+	port  int
+
+	// closed is set by Close, which stops all forwarding.
+	closed bool
+}
+
+// NewSimulator creates an empty Simulator, seeding its jitter and
+// loss with seed.
+func NewSimulator(seed int64) *Simulator {
+	s := &Simulator{
+		seed:  seed,
+		nodes: map[string]*Node{},
+		links: map[[2]string]*simLink{},
+		flows: map[*simFlow]bool{},
+		conns: map[[2]string]int{},
+		port:  firstEphemeralPort,
+	}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+// Node returns the node with the given name, creating it if it
+// doesn't exist.
+func (s *Simulator) Node(name string) *Node {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n, ok := s.nodes[name]
+	if !ok {
+		n = &Node{sim: s, name: name, listeners: map[int]*Listener{}}
+		s.nodes[name] = n
+	}
+	return n
+}
+
+// link returns the link from one node to another. The caller must
+// hold s.mu.
+func (s *Simulator) link(from, to string) *simLink {
+	key := [2]string{from, to}
+	l, ok := s.links[key]
+	if !ok {
+		l = &simLink{}
+		s.links[key] = l
+	}
+	return l
+}
+
+// SetLinkLatency sets the one-way latency between nodes a and b, in
+// both directions. Each chunk of data is delayed by latency plus a
+// random amount up to jitter, but data is never reordered. The new
+// latency applies to data sent from now on.
+func (s *Simulator) SetLinkLatency(a, b string, latency, jitter time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, l := range []*simLink{s.link(a, b), s.link(b, a)} {
+		l.latency, l.jitter = latency, jitter
+	}
+}
+
+// SetLinkLoss makes the link between nodes a and b lose each chunk of
+// data with probability rate, in both directions. As TCP retransmits
+// lost data, a lost chunk isn't dropped, but is delayed by a
+// retransmission timeout of 200ms, once for each time it is lost, up
+// to 15 times.
+func (s *Simulator) SetLinkLoss(a, b string, rate float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.link(a, b).loss = rate
+	s.link(b, a).loss = rate
+}
+
+// Partition cuts the link between nodes a and b in both directions.
+// New dials between them fail with ETIMEDOUT, and data sent on
+// existing connections is held back, as TCP would keep retransmitting
+// it, until the partition is healed.
+func (s *Simulator) Partition(a, b string) {
+	s.setDown(a, b, true)
+	s.setDown(b, a, true)
+}
+
+// PartitionOneWay cuts the link from node from to node to, leaving
+// the other direction working: data sent from to reaches from, but
+// not the other way around. As a TCP handshake needs both directions,
+// new dials between the nodes fail with ETIMEDOUT.
+func (s *Simulator) PartitionOneWay(from, to string) {
+	s.setDown(from, to, true)
+}
+
+// Heal restores the link between nodes a and b in both directions.
+// Data held back by the partition is delivered.
+func (s *Simulator) Heal(a, b string) {
+	s.setDown(a, b, false)
+	s.setDown(b, a, false)
+}
+
+func (s *Simulator) setDown(from, to string, down bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.link(from, to).down = down
+	s.cond.Broadcast()
+}
+
+// DropInFlight discards the data in flight between nodes a and b, in
+// both directions, on every live connection: data that has been sent
+// but not yet delivered, because of latency or a partition, is lost.
+// Unlike a real network, where TCP would recover it, the data is
+// simply missing from the stream, which can be used to check that an
+// application protocol detects gaps. It returns the number of bytes
+// dropped.
+func (s *Simulator) DropInFlight(a, b string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var dropped int
+	for f := range s.flows {
+		if f.from == a && f.to == b || f.from == b && f.to == a {
+			dropped += f.drop()
+		}
+	}
+	s.cond.Broadcast()
+	return dropped
+}
+
+// Close stops the simulator: all forwarding stops, and the simulated
+// connections are closed.
+func (s *Simulator) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	s.cond.Broadcast()
+	s.mu.Unlock()
+	return nil
+}
+
+// Node is a named host in a Simulator.
+type Node struct {
+	sim  *Simulator
+	name string
+
+	mu        sync.Mutex
+	listeners map[int]*Listener
+}
+
+// Name returns the node's name.
+func (n *Node) Name() string {
+	return n.name
+}
+
+// dialError returns the error for a failed dial from a node.
+func (n *Node) dialError(addr Addr, errno syscall.Errno) error {
+	return &net.OpError{
+		Op:   "dial",
+		Net:  addr.Network(),
+		Addr: addr,
+		Err:  os.NewSyscallError("connect", errno),
+	}
+}
+
+// Listen creates a Listener for the given port on the node. Other
+// nodes may connect to it by dialing "name:port"; the Listener's own
+// Dial methods bypass the simulator. It is an error to listen on a
+// port that is already in use.
+func (n *Node) Listen(port int) (*Listener, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	addr := net.JoinHostPort(n.name, strconv.Itoa(port))
+	if _, ok := n.listeners[port]; ok {
+		return nil, &net.OpError{
+			Op:   "listen",
+			Net:  Addr(addr).Network(),
+			Addr: Addr(addr),
+			Err:  os.NewSyscallError("bind", syscall.EADDRINUSE),
+		}
+	}
+
+	l := NewListener(addr, 0)
+	l.unregister = func() {
+		n.mu.Lock()
+		if n.listeners[port] == l {
+			delete(n.listeners, port)
+		}
+		n.mu.Unlock()
+	}
+	n.listeners[port] = l
+	return l, nil
+}
+
+// Dial connects from the node to address, which has the form
+// "node:port". The network is ignored.
+func (n *Node) Dial(network, address string) (net.Conn, error) {
+	return n.DialContext(context.Background(), network, address)
+}
+
+// DialContext connects from the node to address, which has the form
+// "node:port", waiting until ctx is done if the listener isn't
+// accepting connections yet. The network is ignored, so that
+// DialContext may be used as the dial function for an http.Transport.
+func (n *Node) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	raddr := Addr(address)
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, &net.OpError{Op: "dial", Net: raddr.Network(), Addr: raddr, Err: err}
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, &net.OpError{Op: "dial", Net: raddr.Network(), Addr: raddr, Err: err}
+	}
+
+	s := n.sim
+	s.mu.Lock()
+	down := s.link(n.name, host).down || s.link(host, n.name).down
+	target := s.nodes[host]
+	laddr := Addr(net.JoinHostPort(n.name, strconv.Itoa(s.port)))
+	s.port++
+	s.mu.Unlock()
+
+	if down {
+		return nil, n.dialError(raddr, syscall.ETIMEDOUT)
+	} else if target == nil {
+		return nil, n.dialError(raddr, syscall.EHOSTUNREACH)
+	}
+
+	target.mu.Lock()
+	l := target.listeners[port]
+	target.mu.Unlock()
+	if l == nil {
+		return nil, n.dialError(raddr, syscall.ECONNREFUSED)
+	}
+	if _, err = l.admit(); err != nil {
+		return nil, err
+	}
+
+	// The client and server each get one end of a BufferConn pair,
+	// and the simulator forwards data between the other ends.
+	client := newBufferConn(true, laddr, raddr)
+	server := newBufferConn(true, raddr, laddr)
+	if err = l.enqueue(ctx, server); err != nil {
+		client.Close()
+		server.Close()
+		return nil, err
+	}
+
+	s.forward(n.name, host, client.Peer(), server.Peer())
+	s.forward(host, n.name, server.Peer(), client.Peer())
+	return client, nil
+}
+
+// simChunk is a chunk of data in flight. eof marks the end of the
+// data in one direction.
+type simChunk struct {
+	data []byte
+	due  time.Time
+	eof  bool
+}
+
+// simFlow carries one direction of a simulated connection: data read
+// from src is delivered to dst, subject to the link from one node to
+// the other.
+type simFlow struct {
+	sim      *Simulator
+	from, to string
+	src, dst *BufferConn
+
+	// prng draws the flow's jitter and loss, and is guarded by
+	// sim.mu.
+	prng *rand.Rand
+
+	// queue holds the data in flight, and is guarded by sim.mu.
+	queue []simChunk
+}
+
+// forward starts carrying data from src to dst.
+func (s *Simulator) forward(from, to string, src, dst *BufferConn) {
+	f := &simFlow{sim: s, from: from, to: to, src: src, dst: dst}
+
+	s.mu.Lock()
+	key := [2]string{from, to}
+	f.prng = rand.New(rand.NewSource(s.flowSeed(from, to, s.conns[key])))
+	s.conns[key]++
+	s.flows[f] = true
+	s.mu.Unlock()
+
+	go f.read()
+	go f.deliver()
+}
+
+// flowSeed returns the seed for the nth flow from one node to
+// another, which doesn't depend on flows between other nodes.
+func (s *Simulator) flowSeed(from, to string, n int) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(from))
+	h.Write([]byte{0})
+	h.Write([]byte(to))
+	h.Write([]byte{0})
+	h.Write([]byte(strconv.Itoa(n)))
+	return s.seed ^ int64(h.Sum64())
+}
+
+// drop discards the data in the queue, keeping any end of data
+// marker, and returns the number of bytes discarded. The caller must
+// hold sim.mu.
+func (f *simFlow) drop() int {
+	var dropped int
+	var kept []simChunk
+	for _, c := range f.queue {
+		if c.eof {
+			kept = append(kept, c)
+		} else {
+			dropped += len(c.data)
+		}
+	}
+	f.queue = kept
+	return dropped
+}
+
+// done removes the flow from the simulator.
+func (f *simFlow) done() {
+	f.sim.mu.Lock()
+	delete(f.sim.flows, f)
+	f.sim.mu.Unlock()
+}
+
+// read reads data from src and queues it for delivery.
+func (f *simFlow) read() {
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := f.src.Read(buf)
+		if n > 0 {
+			f.push(simChunk{data: append([]byte(nil), buf[:n]...)})
+		}
+		if err != nil {
+			f.push(simChunk{eof: true})
+			return
+		}
+	}
+}
+
+// push queues a chunk, due after the link's latency. Chunks are never
+// due before the chunk ahead of them.
+func (f *simFlow) push(c simChunk) {
+	s := f.sim
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	l := s.link(f.from, f.to)
+	delay := l.latency
+	if l.jitter > 0 {
+		delay += time.Duration(f.prng.Int63n(int64(l.jitter)))
+	}
+	for i := 0; i < simMaxRetransmits && l.loss > 0 && f.prng.Float64() < l.loss; i++ {
+		delay += simRetransmit
+	}
+
+	c.due = time.Now().Add(delay)
+	if len(f.queue) > 0 && c.due.Before(f.queue[len(f.queue)-1].due) {
+		c.due = f.queue[len(f.queue)-1].due
+	}
+	f.queue = append(f.queue, c)
+	s.cond.Broadcast()
+}
+
+// next waits for the chunk at the head of the queue to be due and for
+// the link to be up, then removes and returns it. It returns false if
+// the simulator has been closed.
+func (f *simFlow) next() (simChunk, bool) {
+	s := f.sim
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for {
+		switch {
+		case s.closed:
+			return simChunk{}, false
+		case len(f.queue) == 0 || s.link(f.from, f.to).down:
+			s.cond.Wait()
+			continue
+		}
+
+		c := f.queue[0]
+		if wait := time.Until(c.due); wait > 0 {
+			timer := time.AfterFunc(wait, s.wake)
+			s.cond.Wait()
+			timer.Stop()
+			continue
+		}
+
+		f.queue = f.queue[1:]
+		return c, true
+	}
+}
+
+func (s *Simulator) wake() {
+	s.mu.Lock()
+	s.cond.Broadcast()
+	s.mu.Unlock()
+}
+
+// deliver writes queued data to dst as it becomes due.
+func (f *simFlow) deliver() {
+	defer f.done()
+
+	for {
+		c, ok := f.next()
+		if !ok {
+			f.src.Close()
+			f.dst.Close()
+			return
+		}
+
+		if c.eof {
+			f.dst.CloseWrite()
+			return
+		}
+
+		if _, err := f.dst.Write(c.data); err != nil {
+			// The receiver has gone away, so nothing more can
+			// be delivered.
+			f.src.Close()
+			return
+		}
+	}
+}
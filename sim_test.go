@@ -0,0 +1,318 @@
+package testio
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// simEcho starts an echo server on port 7 of the named node.
+func simEcho(t *testing.T, sim *Simulator, name string) {
+	l, err := sim.Node(name).Listen(7)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(c, c)
+				c.Close()
+			}()
+		}
+	}()
+}
+
+// simRoundTrip writes msg to c and reads it back.
+func simRoundTrip(c net.Conn, msg string) error {
+	if _, err := c.Write([]byte(msg)); err != nil {
+		return err
+	}
+
+	p := make([]byte, len(msg))
+	if _, err := io.ReadFull(c, p); err != nil {
+		return err
+	} else if string(p) != msg {
+		return errors.New("expected '" + msg + "', have '" + string(p) + "'")
+	}
+	return nil
+}
+
+func TestSimulator(t *testing.T) {
+	sim := NewSimulator(1)
+	defer sim.Close()
+	simEcho(t, sim, "b")
+
+	sim.SetLinkLatency("a", "b", 20*time.Millisecond, 5*time.Millisecond)
+	c, err := sim.Node("a").Dial("tcp", "b:7")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer c.Close()
+
+	if c.RemoteAddr().String() != "b:7" {
+		t.Fatalf("expected remote address 'b:7', have '%s'", c.RemoteAddr())
+	}
+
+	start := time.Now()
+	if err = simRoundTrip(c, "hello"); err != nil {
+		t.Fatalf("%v", err)
+	} else if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Fatalf("expected a round trip of at least 40ms, have %v", elapsed)
+	}
+
+	// Closing the client is seen by the server, which closes its
+	// end in turn.
+	c.(*BufferConn).CloseWrite()
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err = c.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expected EOF, have %v", err)
+	}
+}
+
+func TestSimulatorPartition(t *testing.T) {
+	sim := NewSimulator(1)
+	defer sim.Close()
+	simEcho(t, sim, "b")
+
+	c, err := sim.Node("a").Dial("tcp", "b:7")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer c.Close()
+
+	sim.Partition("a", "b")
+	_, err = sim.Node("a").Dial("tcp", "b:7")
+	if !errors.Is(err, syscall.ETIMEDOUT) {
+		t.Fatalf("expected ETIMEDOUT dialing across a partition, have %v", err)
+	}
+
+	c.Write([]byte("held"))
+	c.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	if _, err = c.Read(make([]byte, 4)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected the read to time out, have %v", err)
+	}
+
+	sim.Heal("a", "b")
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	p := make([]byte, 4)
+	if _, err = io.ReadFull(c, p); err != nil {
+		t.Fatalf("%v", err)
+	} else if string(p) != "held" {
+		t.Fatalf("expected the held data to be delivered, have '%s'", p)
+	}
+}
+
+func TestSimulatorDialErrors(t *testing.T) {
+	sim := NewSimulator(1)
+	defer sim.Close()
+	simEcho(t, sim, "b")
+
+	a := sim.Node("a")
+	if _, err := a.Dial("tcp", "c:7"); !errors.Is(err, syscall.EHOSTUNREACH) {
+		t.Fatalf("expected EHOSTUNREACH, have %v", err)
+	}
+	if _, err := a.Dial("tcp", "b:8"); !errors.Is(err, syscall.ECONNREFUSED) {
+		t.Fatalf("expected ECONNREFUSED, have %v", err)
+	}
+	if _, err := sim.Node("b").Listen(7); !errors.Is(err, syscall.EADDRINUSE) {
+		t.Fatalf("expected EADDRINUSE, have %v", err)
+	}
+	if _, err := a.Dial("tcp", "b"); err == nil {
+		t.Fatal("expected an address without a port to fail")
+	}
+}
+
+// simAccept listens on port 9 of node b, dials it from node a, and
+// returns both ends of the connection.
+func simAccept(t *testing.T, sim *Simulator) (client, server net.Conn) {
+	l, err := sim.Node("b").Listen(9)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, _ := l.Accept()
+		accepted <- c
+	}()
+
+	client, err = sim.Node("a").Dial("tcp", "b:9")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	return client, <-accepted
+}
+
+func TestSimulatorPartitionOneWay(t *testing.T) {
+	sim := NewSimulator(1)
+	defer sim.Close()
+	client, server := simAccept(t, sim)
+
+	sim.PartitionOneWay("a", "b")
+	if _, err := sim.Node("b").Dial("tcp", "a:9"); !errors.Is(err, syscall.ETIMEDOUT) {
+		t.Fatalf("expected ETIMEDOUT dialing across a one-way partition, have %v", err)
+	}
+
+	// b can still reach a.
+	server.Write([]byte("pong"))
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	p := make([]byte, 4)
+	if _, err := io.ReadFull(client, p); err != nil {
+		t.Fatalf("%v", err)
+	} else if string(p) != "pong" {
+		t.Fatalf("expected 'pong', have '%s'", p)
+	}
+
+	// a can't reach b until the partition is healed.
+	client.Write([]byte("ping"))
+	server.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	if _, err := server.Read(p); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected the read to time out, have %v", err)
+	}
+
+	sim.Heal("a", "b")
+	server.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := io.ReadFull(server, p); err != nil {
+		t.Fatalf("%v", err)
+	} else if string(p) != "ping" {
+		t.Fatalf("expected 'ping', have '%s'", p)
+	}
+}
+
+func TestSimulatorLinkLoss(t *testing.T) {
+	sim := NewSimulator(1)
+	defer sim.Close()
+	simEcho(t, sim, "b")
+
+	c, err := sim.Node("a").Dial("tcp", "b:7")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer c.Close()
+
+	// With half of all chunks lost, some round trip should be held
+	// up by a retransmission.
+	sim.SetLinkLoss("a", "b", 0.5)
+	for i := 0; i < 20; i++ {
+		start := time.Now()
+		if err = simRoundTrip(c, "hello"); err != nil {
+			t.Fatalf("%v", err)
+		}
+		if time.Since(start) >= simRetransmit {
+			return
+		}
+	}
+	t.Fatal("expected a lost chunk to be retransmitted")
+}
+
+func TestSimulatorDropInFlight(t *testing.T) {
+	sim := NewSimulator(1)
+	defer sim.Close()
+	client, server := simAccept(t, sim)
+
+	// Hold the data back with a partition, so that it is still in
+	// flight when it is dropped.
+	sim.Partition("a", "b")
+	client.Write([]byte("lost"))
+
+	var dropped int
+	for deadline := time.Now().Add(time.Second); dropped < 4; {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected 4 bytes to be dropped, have %d", dropped)
+		}
+		dropped += sim.DropInFlight("b", "a")
+		time.Sleep(time.Millisecond)
+	}
+
+	sim.Heal("a", "b")
+	client.Write([]byte("kept"))
+	server.SetReadDeadline(time.Now().Add(time.Second))
+	p := make([]byte, 4)
+	if _, err := io.ReadFull(server, p); err != nil {
+		t.Fatalf("%v", err)
+	} else if string(p) != "kept" {
+		t.Fatalf("expected 'kept', have '%s'", p)
+	}
+}
+
+// simJitter dials b from a, with a connection from c to b made first
+// if other is set, and returns the first value drawn from the a to b
+// flow's source of jitter.
+func simJitter(t *testing.T, other bool) int64 {
+	sim := NewSimulator(1)
+	defer sim.Close()
+	simEcho(t, sim, "b")
+
+	if other {
+		c, err := sim.Node("c").Dial("tcp", "b:7")
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		defer c.Close()
+	}
+
+	c, err := sim.Node("a").Dial("tcp", "b:7")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer c.Close()
+
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	for f := range sim.flows {
+		if f.from == "a" {
+			return f.prng.Int63()
+		}
+	}
+	t.Fatal("expected a flow from a to b")
+	return 0
+}
+
+func TestSimulatorReproducible(t *testing.T) {
+	if simJitter(t, false) != simJitter(t, true) {
+		t.Fatal("expected the same seed to give the same jitter")
+	}
+}
+
+func TestConnSimulator(t *testing.T) {
+	sim := NewSimulator(1)
+	defer sim.Close()
+	sim.SetLinkLatency("a", "b", time.Millisecond, time.Millisecond)
+
+	l, err := sim.Node("b").Listen(80)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer l.Close()
+
+	TestConn(t, func() (net.Conn, net.Conn, func(), error) {
+		accepted := make(chan net.Conn, 1)
+		go func() {
+			c, _ := l.Accept()
+			accepted <- c
+		}()
+
+		c1, err := sim.Node("a").Dial("tcp", "b:80")
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		c2 := <-accepted
+
+		stop := func() {
+			c1.Close()
+			c2.Close()
+		}
+		return c1, c2, stop, nil
+	})
+}
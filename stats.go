@@ -0,0 +1,101 @@
+package testio
+
+import (
+	"io"
+	"sync"
+)
+
+// Stats counts the reads and writes made on one of the testio types.
+type Stats struct {
+	// BytesRead and BytesWritten are the number of bytes
+	// transferred.
+	BytesRead, BytesWritten int64
+
+	// Reads and Writes count the calls to Read and Write.
+	Reads, Writes int
+
+	// ShortReads and ShortWrites count the calls that transferred
+	// fewer bytes than the length of the buffer they were given.
+	ShortReads, ShortWrites int
+
+	// ReadErrors and WriteErrors count the calls that returned an
+	// error. io.EOF isn't counted as an error.
+	ReadErrors, WriteErrors int
+
+	// LastError is the most recent error returned by a read or
+	// write, other than io.EOF.
+	LastError error
+
+	// ReadSizes and WriteSizes are histograms of the size of the
+	// buffer given to each call, which can be used to check how
+	// code under test buffers its I/O.
+	ReadSizes, WriteSizes map[int]int
+}
+
+// statsRecorder keeps a Stats up to date. The zero value is ready to
+// use.
+type statsRecorder struct {
+	mu sync.Mutex
+	s  Stats
+}
+
+// read records a call to Read with a buffer of size bytes that
+// returned (n, err).
+func (r *statsRecorder) read(size, n int, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.s.ReadSizes == nil {
+		r.s.ReadSizes = map[int]int{}
+	}
+
+	r.s.Reads++
+	r.s.BytesRead += int64(n)
+	r.s.ReadSizes[size]++
+	if n < size {
+		r.s.ShortReads++
+	}
+	if err != nil && err != io.EOF {
+		r.s.ReadErrors++
+		r.s.LastError = err
+	}
+}
+
+// write records a call to Write with a buffer of size bytes that
+// returned (n, err).
+func (r *statsRecorder) write(size, n int, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.s.WriteSizes == nil {
+		r.s.WriteSizes = map[int]int{}
+	}
+
+	r.s.Writes++
+	r.s.BytesWritten += int64(n)
+	r.s.WriteSizes[size]++
+	if n < size {
+		r.s.ShortWrites++
+	}
+	if err != nil {
+		r.s.WriteErrors++
+		r.s.LastError = err
+	}
+}
+
+// stats returns a copy of the current Stats.
+func (r *statsRecorder) stats() Stats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s := r.s
+	s.ReadSizes = map[int]int{}
+	for size, count := range r.s.ReadSizes {
+		s.ReadSizes[size] = count
+	}
+	s.WriteSizes = map[int]int{}
+	for size, count := range r.s.WriteSizes {
+		s.WriteSizes[size] = count
+	}
+	return s
+}
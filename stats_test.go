@@ -0,0 +1,111 @@
+package testio
+
+import (
+	"bufio"
+	"io"
+	"testing"
+)
+
+func TestStatsBrokenWriter(t *testing.T) {
+	w := NewBrokenWriter(6)
+	w.Write([]byte("AB"))
+	w.Write([]byte("CD"))
+	w.Write([]byte("EFGH"))
+	w.Write([]byte("I"))
+
+	s := w.Stats()
+	if s.Writes != 4 {
+		t.Fatalf("expected 4 writes, have %d", s.Writes)
+	} else if s.BytesWritten != 6 {
+		t.Fatalf("expected 6 bytes written, have %d", s.BytesWritten)
+	} else if s.ShortWrites != 2 {
+		t.Fatalf("expected 2 short writes, have %d", s.ShortWrites)
+	} else if s.WriteErrors != 2 {
+		t.Fatalf("expected 2 write errors, have %d", s.WriteErrors)
+	} else if s.LastError == nil {
+		t.Fatal("expected the last error to be recorded")
+	} else if s.WriteSizes[2] != 2 || s.WriteSizes[4] != 1 || s.WriteSizes[1] != 1 {
+		t.Fatalf("unexpected write sizes %v", s.WriteSizes)
+	}
+
+	// The returned Stats is a copy.
+	s.WriteSizes[2] = 0
+	if w.Stats().WriteSizes[2] != 2 {
+		t.Fatal("changing the returned Stats shouldn't affect the writer")
+	}
+}
+
+func TestStatsBuffering(t *testing.T) {
+	buf := NewBufCloser(nil)
+	bw := bufio.NewWriterSize(buf, 16)
+	for i := 0; i < 10; i++ {
+		bw.Write([]byte("ABCD"))
+	}
+	bw.Flush()
+
+	s := buf.Stats()
+	if s.Writes != 3 || s.WriteSizes[16] != 2 || s.WriteSizes[8] != 1 {
+		t.Fatalf("expected writes of 16, 16 and 8 bytes, have %v", s.WriteSizes)
+	}
+
+	p := make([]byte, 32)
+	buf.Read(p)
+	buf.Read(p)
+	buf.Read(p)
+
+	s = buf.Stats()
+	if s.Reads != 3 || s.BytesRead != 40 {
+		t.Fatalf("expected 3 reads of 40 bytes, have %d reads of %d bytes",
+			s.Reads, s.BytesRead)
+	} else if s.ShortReads != 2 {
+		t.Fatalf("expected 2 short reads, have %d", s.ShortReads)
+	} else if s.ReadErrors != 0 || s.LastError != nil {
+		t.Fatalf("io.EOF shouldn't count as an error, have %v", s.LastError)
+	}
+}
+
+func TestStatsBufferConn(t *testing.T) {
+	bc := NewBufferConn()
+	bc.Write([]byte("ABC"))
+	bc.WritePeer([]byte("XY"))
+	bc.Read(make([]byte, 4))
+	bc.Read(make([]byte, 4))
+
+	s := bc.Stats()
+	if s.Writes != 1 || s.BytesWritten != 3 {
+		t.Fatalf("expected 1 write of 3 bytes, have %d writes of %d bytes",
+			s.Writes, s.BytesWritten)
+	} else if s.Reads != 2 || s.BytesRead != 2 {
+		t.Fatalf("expected 2 reads of 2 bytes, have %d reads of %d bytes",
+			s.Reads, s.BytesRead)
+	}
+
+	s = bc.Peer().Stats()
+	if s.Writes != 1 || s.BytesWritten != 2 {
+		t.Fatalf("expected the peer to write 2 bytes, have %d writes of %d bytes",
+			s.Writes, s.BytesWritten)
+	}
+
+	bc.InjectFault(FaultReset)
+	_, err := bc.Write([]byte("A"))
+	if s = bc.Stats(); s.WriteErrors != 1 || s.LastError != err {
+		t.Fatalf("expected the write error to be recorded, have %v", s.LastError)
+	}
+}
+
+func TestStatsReadWriters(t *testing.T) {
+	brw := NewBrokenReadWriter(4, 2)
+	brw.Write([]byte("ABCD"))
+	brw.Read(make([]byte, 4))
+	if s := brw.Stats(); s.Writes != 1 || s.Reads != 1 || s.BytesWritten != 4 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+
+	lb := NewLoggingBuffer(NewBufCloser(nil))
+	lb.LogTo(io.Discard)
+	lb.Write([]byte("AB"))
+	lb.Read(make([]byte, 2))
+	if s := lb.Stats(); s.BytesWritten != 2 || s.BytesRead != 2 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+}
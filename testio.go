@@ -1,10 +1,18 @@
 // Package testio implements various io utility types. Included are
 // BrokenWriter, which fails after writing a certain number of bytes;
-// a BufCloser, which wraps a bytes.Buffer in a Close method; a
+// a BrokenReader, which fails after reading a certain number of
+// bytes; a BufCloser, which wraps a bytes.Buffer in a Close method; a
 // BrokenReadWriter, which fails after writing a certain number of
-// bytes and/or reading a certain number of bytes; a LoggingBuffer
+// bytes and/or reading a certain number of bytes; ChunkedReader and
+// ChunkedWriter, which split I/O into small calls; a LoggingBuffer
 // that logs all reads and writes; and a BufferConn, that is designed
-// to simulate net.Conn.
+// to simulate net.Conn. It also provides conformance suites for
+// checking io implementations.
+//
+// All of the types are safe for concurrent use, so that, for example,
+// a BrokenWriter may be extended while another goroutine is copying
+// to it. Wrappers are only as safe as the readers and writers they
+// wrap.
 package testio
 
 import (
@@ -13,14 +21,25 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"sync"
 )
 
 // BrokenWriter implements an io.Writer that fails after a certain
 // number of bytes. This can be used to simulate a network connection
 // that breaks during write or a file on a filesystem that becomes
-// full, for example. A BrokenWriter doesn't actually store any data.
+// full, for example. A BrokenWriter created with NewBrokenWriter
+// doesn't actually store any data; one created with
+// NewStoringBrokenWriter keeps the bytes it accepts, so that a test
+// can check what was written before the failure. It is safe for
+// concurrent use, so Extend and Reset may be called while another
+// goroutine is writing.
 type BrokenWriter struct {
+	mu             sync.Mutex
 	current, limit int
+	stats          statsRecorder
+
+	// buf holds the accepted data if the writer is storing it.
+	buf *bytes.Buffer
 }
 
 // NewBrokenWriter creates a new BrokenWriter that can store only
@@ -29,37 +48,152 @@ func NewBrokenWriter(limit int) *BrokenWriter {
 	return &BrokenWriter{limit: limit}
 }
 
+// NewStoringBrokenWriter creates a new BrokenWriter that accepts only
+// limit bytes, and keeps them so that they can be retrieved with
+// Bytes.
+func NewStoringBrokenWriter(limit int) *BrokenWriter {
+	return &BrokenWriter{limit: limit, buf: &bytes.Buffer{}}
+}
+
 // Write will write the byte slice to the BrokenWriter, failing if the
 // maximum number of bytes has been reached.
 func (w *BrokenWriter) Write(p []byte) (int, error) {
+	n, err := w.write(p)
+	w.stats.write(len(p), n, err)
+	return n, err
+}
+
+func (w *BrokenWriter) write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
 	if (len(p) + w.current) <= w.limit {
 		w.current += len(p)
+		w.store(p)
 	} else {
 		spill := (len(p) + w.current) - w.limit
 		w.current = w.limit
+		w.store(p[:len(p)-spill])
 		return len(p) - spill, errors.New("testio: write failed")
 	}
 
 	return len(p), nil
 }
 
+// store keeps p if the writer is storing data. The caller must hold
+// w.mu.
+func (w *BrokenWriter) store(p []byte) {
+	if w.buf != nil {
+		w.buf.Write(p)
+	}
+}
+
+// Bytes returns a copy of the data accepted by a BrokenWriter created
+// with NewStoringBrokenWriter. It returns nil for one that doesn't
+// store data.
+func (w *BrokenWriter) Bytes() []byte {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.buf == nil {
+		return nil
+	}
+	return append([]byte(nil), w.buf.Bytes()...)
+}
+
 // Extend increases the byte limit to allow more data to be written.
 func (w *BrokenWriter) Extend(n int) {
+	w.mu.Lock()
 	w.limit += n
+	w.mu.Unlock()
 }
 
-// Reset clears the limit and bytes in the BrokenWriter. Extend needs
-// to be called to allow data to be written.
+// Reset clears the limit and bytes in the BrokenWriter, including any
+// stored data. Extend needs to be called to allow data to be written.
 func (w *BrokenWriter) Reset() {
+	w.mu.Lock()
 	w.limit = 0
 	w.current = 0
+	if w.buf != nil {
+		w.buf.Reset()
+	}
+	w.mu.Unlock()
+}
+
+// Stats returns counts of the writes made so far. They aren't
+// cleared by Reset.
+func (w *BrokenWriter) Stats() Stats {
+	return w.stats.stats()
+}
+
+// BrokenReader implements an io.Reader that fails after a certain
+// number of bytes have been read from the underlying reader. It can
+// be used to simulate a network connection that breaks during a
+// read, for example. It is safe for concurrent use: reads are
+// serialised, and Extend may be called while a read is blocked in the
+// underlying reader.
+type BrokenReader struct {
+	r io.Reader
+
+	// rmu is held for the whole of a read, and mu guards the
+	// counters.
+	rmu            sync.Mutex
+	mu             sync.Mutex
+	current, limit int
+}
+
+// NewBrokenReader creates a new BrokenReader that will read at most
+// limit bytes from r.
+func NewBrokenReader(r io.Reader, limit int) *BrokenReader {
+	return &BrokenReader{r: r, limit: limit}
+}
+
+// Read reads from the underlying reader, failing once the maximum
+// number of bytes has been read. If a read reaches the limit, the
+// bytes read are returned along with the error.
+func (r *BrokenReader) Read(p []byte) (int, error) {
+	r.rmu.Lock()
+	defer r.rmu.Unlock()
+
+	r.mu.Lock()
+	remain := r.limit - r.current
+	r.mu.Unlock()
+	if remain <= 0 {
+		return 0, errors.New("testio: read failed")
+	}
+
+	if len(p) < remain {
+		n, err := r.r.Read(p)
+		r.mu.Lock()
+		r.current += n
+		r.mu.Unlock()
+		return n, err
+	}
+
+	n, err := r.r.Read(p[:remain])
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.current += n
+	if err == nil && r.current == r.limit {
+		err = errors.New("testio: read failed")
+	}
+	return n, err
+}
+
+// Extend increases the byte limit to allow more data to be read.
+func (r *BrokenReader) Extend(n int) {
+	r.mu.Lock()
+	r.limit += n
+	r.mu.Unlock()
 }
 
 // BrokenReadWriter implements a broken reader and writer, backed by a
-// bytes.Buffer.
+// bytes.Buffer. It is safe for concurrent use.
 type BrokenReadWriter struct {
+	mu             sync.Mutex
 	rlimit, wlimit int
 	buf            *bytes.Buffer
+	stats          statsRecorder
 }
 
 // NewBrokenReadWriter initialises a new BrokerReadWriter with an empty
@@ -74,6 +208,15 @@ func NewBrokenReadWriter(wlimit, rlimit int) *BrokenReadWriter {
 
 // Write satisfies the Writer interface.
 func (brw *BrokenReadWriter) Write(p []byte) (int, error) {
+	n, err := brw.write(p)
+	brw.stats.write(len(p), n, err)
+	return n, err
+}
+
+func (brw *BrokenReadWriter) write(p []byte) (int, error) {
+	brw.mu.Lock()
+	defer brw.mu.Unlock()
+
 	if (len(p) + brw.buf.Len()) > brw.wlimit {
 		remain := brw.wlimit - brw.buf.Len()
 		if remain > 0 {
@@ -87,6 +230,15 @@ func (brw *BrokenReadWriter) Write(p []byte) (int, error) {
 
 // Read satisfies the Reader interface.
 func (brw *BrokenReadWriter) Read(p []byte) (int, error) {
+	n, err := brw.read(p)
+	brw.stats.read(len(p), n, err)
+	return n, err
+}
+
+func (brw *BrokenReadWriter) read(p []byte) (int, error) {
+	brw.mu.Lock()
+	defer brw.mu.Unlock()
+
 	remain := brw.rlimit - brw.buf.Len()
 	if len(p) > remain {
 		tmp := make([]byte, len(p)-remain)
@@ -102,28 +254,51 @@ func (brw *BrokenReadWriter) Read(p []byte) (int, error) {
 
 // Extend increases the BrokenReadWriter limit.
 func (brw *BrokenReadWriter) Extend(w, r int) {
+	brw.mu.Lock()
 	brw.rlimit += r
 	brw.wlimit += w
+	brw.mu.Unlock()
 }
 
 // Reset clears the internal buffer. It retains its original limit.
 func (brw *BrokenReadWriter) Reset() {
+	brw.mu.Lock()
 	brw.buf.Reset()
+	brw.mu.Unlock()
 }
 
-// BufCloser is a buffer wrapped with a Close method.
+// Stats returns counts of the reads and writes made so far. They
+// aren't cleared by Reset.
+func (brw *BrokenReadWriter) Stats() Stats {
+	return brw.stats.stats()
+}
+
+// BufCloser is a buffer wrapped with a Close method. It is safe for
+// concurrent use.
 type BufCloser struct {
-	buf *bytes.Buffer
+	mu    sync.Mutex
+	buf   *bytes.Buffer
+	stats statsRecorder
 }
 
 // Write writes the data to the BufCloser.
 func (buf *BufCloser) Write(p []byte) (int, error) {
-	return buf.buf.Write(p)
+	buf.mu.Lock()
+	n, err := buf.buf.Write(p)
+	buf.mu.Unlock()
+
+	buf.stats.write(len(p), n, err)
+	return n, err
 }
 
 // Read reads data from the BufCloser.
 func (buf *BufCloser) Read(p []byte) (int, error) {
-	return buf.buf.Read(p)
+	buf.mu.Lock()
+	n, err := buf.buf.Read(p)
+	buf.mu.Unlock()
+
+	buf.stats.read(len(p), n, err)
+	return n, err
 }
 
 // Close is a stub function to satisfy the io.Closer interface.
@@ -133,12 +308,23 @@ func (buf *BufCloser) Close() error {
 
 // Reset clears the internal buffer.
 func (buf *BufCloser) Reset() {
+	buf.mu.Lock()
 	buf.buf.Reset()
+	buf.mu.Unlock()
 }
 
-// Bytes returns the contents of the buffer as a byte slice.
+// Bytes returns a copy of the unread contents of the buffer.
 func (buf *BufCloser) Bytes() []byte {
-	return buf.buf.Bytes()
+	buf.mu.Lock()
+	defer buf.mu.Unlock()
+
+	return append([]byte(nil), buf.buf.Bytes()...)
+}
+
+// Stats returns counts of the reads and writes made so far. They
+// aren't cleared by Reset.
+func (buf *BufCloser) Stats() Stats {
+	return buf.stats.stats()
 }
 
 // NewBufCloser creates and initializes a new BufCloser using buf as
@@ -161,12 +347,92 @@ func NewBufCloserString(s string) *BufCloser {
 	return buf
 }
 
+// ChunkedReader implements an io.Reader that returns at most a fixed
+// number of bytes from each call to Read, regardless of the size of
+// the buffer it is given. It is useful for checking that code copes
+// with short reads. It is safe for concurrent use if the underlying
+// reader is.
+type ChunkedReader struct {
+	r    io.Reader
+	size int
+}
+
+// NewChunkedReader creates a new ChunkedReader that reads at most
+// size bytes at a time from r. A size less than one is treated as
+// one.
+func NewChunkedReader(r io.Reader, size int) *ChunkedReader {
+	if size < 1 {
+		size = 1
+	}
+	return &ChunkedReader{r: r, size: size}
+}
+
+// Read reads up to the chunk size from the underlying reader.
+func (cr *ChunkedReader) Read(p []byte) (int, error) {
+	if len(p) > cr.size {
+		p = p[:cr.size]
+	}
+	return cr.r.Read(p)
+}
+
+// ChunkedWriter implements an io.Writer that splits each write into
+// calls of at most a fixed number of bytes to the underlying writer.
+// It is safe for concurrent use: writes are serialised, so the chunks
+// of different writes aren't interleaved.
+type ChunkedWriter struct {
+	mu   sync.Mutex
+	w    io.Writer
+	size int
+}
+
+// NewChunkedWriter creates a new ChunkedWriter that writes at most
+// size bytes at a time to w. A size less than one is treated as one.
+func NewChunkedWriter(w io.Writer, size int) *ChunkedWriter {
+	if size < 1 {
+		size = 1
+	}
+	return &ChunkedWriter{w: w, size: size}
+}
+
+// Write writes p to the underlying writer in chunks, stopping at the
+// first error.
+func (cw *ChunkedWriter) Write(p []byte) (int, error) {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+
+	var written int
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > cw.size {
+			chunk = chunk[:cw.size]
+		}
+
+		n, err := cw.w.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		if n < len(chunk) {
+			return written, io.ErrShortWrite
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
 // A LoggingBuffer is an io.ReadWriter that prints the hex value of
-// the data for all reads and writes.
+// the data for all reads and writes. It is safe for concurrent use if
+// the underlying io.ReadWriter is. Log lines aren't interleaved, but
+// reads and writes aren't serialised, so a blocked read doesn't hold
+// up writes.
 type LoggingBuffer struct {
-	rw   io.ReadWriter
+	rw io.ReadWriter
+
+	mu   sync.Mutex
 	w    io.Writer
 	name string
+
+	stats statsRecorder
 }
 
 // NewLoggingBuffer creates a logging buffer from an existing
@@ -180,82 +446,54 @@ func NewLoggingBuffer(rw io.ReadWriter) *LoggingBuffer {
 
 // LogTo sets the io.Writer that the buffer will write logs to.
 func (lb *LoggingBuffer) LogTo(w io.Writer) {
+	lb.mu.Lock()
 	lb.w = w
+	lb.mu.Unlock()
 }
 
 // SetName gives a name to the logging buffer to help distinguish
 // output from this buffer.
 func (lb *LoggingBuffer) SetName(name string) {
+	lb.mu.Lock()
 	lb.name = name
+	lb.mu.Unlock()
 }
 
-// Write writes the data to the logging buffer and writes the data to
-// the logging writer.
-func (lb *LoggingBuffer) Write(p []byte) (int, error) {
+// log writes a single log line for an operation.
+func (lb *LoggingBuffer) log(op string, p []byte) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
 	if lb.name != "" {
 		fmt.Fprintf(lb.w, "[%s] ", lb.name)
 	}
 
-	fmt.Fprintf(lb.w, "[WRITE] %x\n", p)
-	return lb.rw.Write(p)
+	fmt.Fprintf(lb.w, "[%s] %x\n", op, p)
+}
+
+// Write writes the data to the logging buffer and writes the data to
+// the logging writer.
+func (lb *LoggingBuffer) Write(p []byte) (int, error) {
+	lb.log("WRITE", p)
+	n, err := lb.rw.Write(p)
+	lb.stats.write(len(p), n, err)
+	return n, err
 }
 
 // Read reads the data from the logging buffer and writes the data to
 // the logging writer.
 func (lb *LoggingBuffer) Read(p []byte) (int, error) {
 	n, err := lb.rw.Read(p)
+	lb.stats.read(len(p), n, err)
 	if err != nil {
 		return n, err
 	}
-	if lb.name != "" {
-		fmt.Fprintf(lb.w, "[%s] ", lb.name)
-	}
 
-	fmt.Fprintf(lb.w, "[READ] %x\n", p)
+	lb.log("READ", p)
 	return n, err
 }
 
-// BufferConn is a type that can be used to simulate network
-// connections between a "client" (the code that uses the BufferConn)
-// and some simulated "peer". Writes go to a "client" buffer, which is
-// used to record the data sent by the caller, which may be read with
-// ReadPeer. The peer's responses may be simulated by calling
-// WritePeer; when the client reads from the BufferConn, they will see
-// this data.
-type BufferConn struct {
-	client, peer *bytes.Buffer
-}
-
-// NewBufferConn initialises a new simulated network connection.
-func NewBufferConn() *BufferConn {
-	return &BufferConn{
-		client: &bytes.Buffer{},
-		peer:   &bytes.Buffer{},
-	}
-}
-
-// Write writes to the client buffer.
-func (bc *BufferConn) Write(p []byte) (int, error) {
-	return bc.client.Write(p)
-}
-
-// Read reads from the peer buffer.
-func (bc *BufferConn) Read(p []byte) (int, error) {
-	return bc.peer.Read(p)
-}
-
-// WritePeer writes data to the peer buffer.
-func (bc *BufferConn) WritePeer(p []byte) (int, error) {
-	return bc.peer.Write(p)
-}
-
-// ReadClient reads data from the client buffer.
-func (bc *BufferConn) ReadClient(p []byte) (int, error) {
-	return bc.client.Read(p)
-}
-
-// Close is a dummy operation that allows the BufferConn to be used as
-// an io.Closer.
-func (bc *BufferConn) Close() error {
-	return nil
+// Stats returns counts of the reads and writes made so far.
+func (lb *LoggingBuffer) Stats() Stats {
+	return lb.stats.stats()
 }
@@ -1,9 +1,18 @@
 package testio
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
 	"os"
+	"sync"
+	"syscall"
 	"testing"
+	"time"
 )
 
 func TestBrokenWriter(t *testing.T) {
@@ -35,6 +44,40 @@ func TestBrokenWriter(t *testing.T) {
 	}
 }
 
+func TestStoringBrokenWriter(t *testing.T) {
+	buf := NewStoringBrokenWriter(3)
+	if _, err := buf.Write([]byte("AB")); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	n, err := buf.Write([]byte("CD"))
+	if err == nil {
+		t.Fatal("expected a write failure")
+	} else if n != 1 {
+		t.Fatalf("expected write size of 1, have %d", n)
+	} else if string(buf.Bytes()) != "ABC" {
+		t.Fatalf("expected 'ABC', have '%s'", buf.Bytes())
+	}
+
+	buf.Extend(1)
+	if _, err = buf.Write([]byte("D")); err != nil {
+		t.Fatalf("%v", err)
+	} else if string(buf.Bytes()) != "ABCD" {
+		t.Fatalf("expected 'ABCD', have '%s'", buf.Bytes())
+	}
+
+	buf.Reset()
+	if len(buf.Bytes()) != 0 {
+		t.Fatalf("expected no data after reset, have '%s'", buf.Bytes())
+	} else if _, err = buf.Write([]byte("E")); err == nil {
+		t.Fatal("expected a write failure after reset")
+	}
+
+	if NewBrokenWriter(1).Bytes() != nil {
+		t.Fatal("a BrokenWriter that doesn't store data should have no bytes")
+	}
+}
+
 func TestBufCloser(t *testing.T) {
 	var data = []byte{1, 2}
 	var read = make([]byte, 2)
@@ -218,3 +261,447 @@ func TestBufferConn(t *testing.T) {
 		t.Fatalf("Close should always return nil, but it returned %v", err)
 	}
 }
+
+func TestBrokenReader(t *testing.T) {
+	data := []byte("ABCD")
+	r := NewBrokenReader(bytes.NewReader(data), 2)
+
+	p := make([]byte, 4)
+	n, err := r.Read(p)
+	if err == nil {
+		t.Fatal("expected a read failure")
+	} else if n != 2 {
+		t.Fatalf("expected read size of 2, have %d", n)
+	}
+
+	_, err = r.Read(p)
+	if err == nil {
+		t.Fatal("expected a read failure")
+	}
+
+	r.Extend(2)
+	n, err = r.Read(p[:1])
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if p[0] != 'C' {
+		t.Fatalf("expected to read 'C', have '%c'", p[0])
+	}
+}
+
+func TestChunked(t *testing.T) {
+	data := []byte("ABCDE")
+	cr := NewChunkedReader(bytes.NewReader(data), 2)
+
+	p := make([]byte, 5)
+	n, err := cr.Read(p)
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if n != 2 {
+		t.Fatalf("expected read size of 2, have %d", n)
+	}
+
+	buf := &bytes.Buffer{}
+	cw := NewChunkedWriter(NewBrokenWriter(3), 2)
+	n, err = cw.Write(data)
+	if err == nil {
+		t.Fatal("expected a write failure")
+	} else if n != 3 {
+		t.Fatalf("expected write size of 3, have %d", n)
+	}
+
+	cw = NewChunkedWriter(buf, 0)
+	_, err = cw.Write(data)
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if !bytes.Equal(buf.Bytes(), data) {
+		t.Fatalf("expected %x, have %x", data, buf.Bytes())
+	}
+}
+
+func TestBufferConnHalfClose(t *testing.T) {
+	var _ interface {
+		CloseRead() error
+		CloseWrite() error
+	} = &BufferConn{}
+
+	bc := NewBufferConn()
+	_, err := bc.Write([]byte("AB"))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if err = bc.CloseWrite(); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	_, err = bc.Write([]byte("C"))
+	if !errors.Is(err, syscall.EPIPE) {
+		t.Fatalf("expected EPIPE after CloseWrite, have %v", err)
+	}
+
+	p := make([]byte, 2)
+	_, err = bc.ReadClient(p)
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if string(p) != "AB" {
+		t.Fatalf("expected 'AB', have '%s'", p)
+	}
+
+	_, err = bc.ReadClient(p)
+	if err != io.EOF {
+		t.Fatalf("expected EOF after CloseWrite, have %v", err)
+	}
+
+	// The peer can still reply after the client's half-close.
+	_, err = bc.WritePeer([]byte("XY"))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if err = bc.CloseRead(); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	_, err = bc.Read(p)
+	if err != io.EOF {
+		t.Fatalf("expected EOF after CloseRead, have %v", err)
+	}
+
+	c1, c2 := NewBufferConnPair()
+	c2.Write([]byte("XY"))
+	if err = c1.CloseWritePeer(); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	data, err := io.ReadAll(c1)
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if string(data) != "XY" {
+		t.Fatalf("expected 'XY', have '%s'", data)
+	}
+
+	if err = c1.CloseReadClient(); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	_, err = c2.Read(p)
+	if err != io.EOF {
+		t.Fatalf("expected EOF after CloseReadClient, have %v", err)
+	}
+
+	c1.Close()
+	if err = c1.CloseWrite(); err == nil {
+		t.Fatal("expected CloseWrite on a closed conn to fail")
+	}
+}
+
+func TestBufferConnFaults(t *testing.T) {
+	bc := NewBufferConn()
+	bc.FaultAfterWrite(3, FaultReset)
+
+	n, err := bc.Write([]byte("ABCD"))
+	if !errors.Is(err, syscall.ECONNRESET) {
+		t.Fatalf("expected ECONNRESET, have %v", err)
+	} else if n != 3 {
+		t.Fatalf("expected write size of 3, have %d", n)
+	}
+
+	var opErr *net.OpError
+	if !errors.As(err, &opErr) || opErr.Op != "write" {
+		t.Fatalf("expected a *net.OpError, have %#v", err)
+	}
+
+	bc.InjectFault(FaultNone)
+	bc.WritePeer([]byte("XYZ"))
+	bc.FaultAfterRead(2, FaultBrokenPipe)
+
+	p := make([]byte, 3)
+	n, err = bc.Read(p)
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if n != 2 {
+		t.Fatalf("expected read size of 2, have %d", n)
+	}
+
+	_, err = bc.Read(p)
+	if err != io.EOF {
+		t.Fatalf("expected EOF, have %v", err)
+	}
+
+	bc.InjectFault(FaultBrokenPipe)
+	_, err = bc.Write(p)
+	if !errors.Is(err, syscall.EPIPE) {
+		t.Fatalf("expected EPIPE, have %v", err)
+	}
+
+	bc.InjectFault(FaultDrop)
+	n, err = bc.Write([]byte("dropped"))
+	if err != nil || n != 7 {
+		t.Fatalf("expected a dropped write to succeed, have (%d, %v)", n, err)
+	}
+
+	c1, c2 := NewBufferConnPair()
+	c1.InjectFault(FaultStall)
+	c1.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	c2.Write([]byte("A"))
+	_, err = c1.Read(p)
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected a stalled read to time out, have %v", err)
+	}
+
+	// A fault injected from the peer side wakes a blocked read.
+	c1.InjectFault(FaultNone)
+	c1.SetReadDeadline(time.Time{})
+	c1.Read(p)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		c1.InjectFault(FaultReset)
+	}()
+
+	_, err = c1.Read(p)
+	if !errors.Is(err, syscall.ECONNRESET) {
+		t.Fatalf("expected ECONNRESET, have %v", err)
+	}
+}
+
+func TestBufferConnPeer(t *testing.T) {
+	bc := NewBufferConn()
+	peer := bc.Peer()
+	if peer.Peer() != bc {
+		t.Fatal("the peer's peer should be the original conn")
+	}
+
+	var _ net.Conn = peer
+	_, err := bc.Write([]byte("HELO client\r\n"))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	line, err := bufio.NewReader(peer).ReadString('\n')
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if line != "HELO client\r\n" {
+		t.Fatalf("expected the client's greeting, have '%s'", line)
+	}
+
+	_, err = peer.Write([]byte("250 OK"))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	p := make([]byte, 6)
+	_, err = bc.Read(p)
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if string(p) != "250 OK" {
+		t.Fatalf("expected '250 OK', have '%s'", p)
+	}
+
+	if peer.LocalAddr() != bc.RemoteAddr() {
+		t.Fatalf("expected the peer's address to be %s, have %s",
+			bc.RemoteAddr(), peer.LocalAddr())
+	}
+
+	// Run an echo server as the peer of a blocking conn.
+	client, _ := NewBufferConnPair()
+	go io.Copy(client.Peer(), client.Peer())
+
+	_, err = client.Write([]byte("ping"))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	p = make([]byte, 4)
+	_, err = io.ReadFull(client, p)
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if string(p) != "ping" {
+		t.Fatalf("expected 'ping', have '%s'", p)
+	}
+	client.Close()
+}
+
+func TestBufferConnHang(t *testing.T) {
+	c1, c2 := NewBufferConnPair()
+	c1.Hang()
+
+	errs := make(chan error, 2)
+	go func() {
+		_, err := c1.Write([]byte("A"))
+		errs <- err
+	}()
+	go func() {
+		_, err := c1.Read(make([]byte, 1))
+		errs <- err
+	}()
+	waitBlocked(t, c1, 2)
+
+	c2.Write([]byte("B"))
+	c1.Release()
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+	waitBlocked(t, c1, 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c1.HangContext(ctx)
+	go func() {
+		_, err := c1.Read(make([]byte, 1))
+		errs <- err
+	}()
+	waitBlocked(t, c1, 1)
+
+	cancel()
+	if err := <-errs; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, have %v", err)
+	}
+
+	c1.Hang()
+	go func() {
+		_, err := c1.Read(make([]byte, 1))
+		errs <- err
+	}()
+	waitBlocked(t, c1, 1)
+
+	c1.Close()
+	if err := <-errs; !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("expected net.ErrClosed, have %v", err)
+	}
+}
+
+func TestBufferConnHangStaleContext(t *testing.T) {
+	c1, _ := NewBufferConnPair()
+	ctx, cancel := context.WithCancel(context.Background())
+	c1.HangContext(ctx)
+	c1.Release()
+
+	// Cancelling the context of a released hang doesn't end a later
+	// one.
+	c1.Hang()
+	cancel()
+	c1.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if _, err := c1.Read(make([]byte, 1)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected the read to keep blocking until its deadline, have %v", err)
+	}
+}
+
+func TestBufferConnHangBothEnds(t *testing.T) {
+	c1, c2 := NewBufferConnPair()
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	defer cancel1()
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	defer cancel2()
+	c1.HangContext(ctx1)
+	c2.HangContext(ctx2)
+
+	errs1 := make(chan error, 1)
+	errs2 := make(chan error, 1)
+	go func() {
+		_, err := c1.Read(make([]byte, 1))
+		errs1 <- err
+	}()
+	go func() {
+		_, err := c2.Read(make([]byte, 1))
+		errs2 <- err
+	}()
+	waitBlocked(t, c1, 1)
+	waitBlocked(t, c2, 1)
+
+	// Cancelling one end's context only ends that end's hang.
+	cancel1()
+	if err := <-errs1; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, have %v", err)
+	}
+	waitBlocked(t, c2, 1)
+
+	// Injecting a fault on one end doesn't affect the other's
+	// cancelled hang.
+	c2.InjectFault(FaultStall)
+	c1.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := c1.Read(make([]byte, 1)); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, have %v", err)
+	}
+
+	c2.Close()
+	if err := <-errs2; !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("expected net.ErrClosed, have %v", err)
+	}
+}
+
+// TestConcurrentUse exercises each type from several goroutines at
+// once; it is only useful when run with the race detector.
+func TestConcurrentUse(t *testing.T) {
+	const workers = 4
+	wg := &sync.WaitGroup{}
+	run := func(fn func()) {
+		wg.Add(workers)
+		for i := 0; i < workers; i++ {
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 100; j++ {
+					fn()
+				}
+			}()
+		}
+	}
+
+	data := []byte("ABCD")
+	bw := NewBrokenWriter(0)
+	run(func() { bw.Write(data) })
+	run(func() { bw.Extend(4) })
+	run(func() { bw.Reset() })
+
+	br := NewBrokenReader(NewBufCloser(bytes.Repeat(data, 1000)), 0)
+	run(func() { br.Read(make([]byte, 4)) })
+	run(func() { br.Extend(4) })
+
+	brw := NewBrokenReadWriter(0, 0)
+	run(func() { brw.Write(data) })
+	run(func() { brw.Read(make([]byte, 4)) })
+	run(func() { brw.Extend(4, 4) })
+	run(func() { brw.Reset() })
+
+	buf := NewBufCloser(nil)
+	run(func() { buf.Write(data) })
+	run(func() { buf.Read(make([]byte, 4)) })
+	run(func() { buf.Bytes() })
+	run(func() { buf.Reset() })
+
+	cw := NewChunkedWriter(NewBufCloser(nil), 3)
+	run(func() { cw.Write(data) })
+
+	log := NewBufCloser(nil)
+	lb := NewLoggingBuffer(NewBufCloser(nil))
+	lb.LogTo(log)
+	run(func() { lb.Write(data) })
+	run(func() { lb.Read(make([]byte, 4)) })
+	run(func() { lb.SetName("TEST") })
+	run(func() { lb.LogTo(log) })
+
+	wg.Wait()
+}
+
+func TestBrokenReaderExtendWhileBlocked(t *testing.T) {
+	client, peer := NewBufferConnPair()
+	br := NewBrokenReader(client, 0)
+	br.Extend(2)
+
+	done := make(chan error)
+	go func() {
+		p := make([]byte, 4)
+		n, err := br.Read(p)
+		if err == nil && n != 1 {
+			err = fmt.Errorf("expected to read 1 byte, read %d", n)
+		}
+		done <- err
+	}()
+
+	// Extend mustn't wait for the blocked read to finish.
+	br.Extend(2)
+	peer.Write([]byte("A"))
+	if err := <-done; err != nil {
+		t.Fatalf("%v", err)
+	}
+}
@@ -0,0 +1,252 @@
+package testio
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"sync"
+	"time"
+)
+
+// CA is a certificate authority for tests. It generates its own key
+// and self-signed certificate, and issues leaf certificates on demand.
+type CA struct {
+	// Cert is the CA's certificate.
+	Cert *x509.Certificate
+
+	key *ecdsa.PrivateKey
+
+	mu     sync.Mutex
+	serial int64
+}
+
+// NewCA creates a new certificate authority.
+func NewCA() (*CA, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "testio CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CA{Cert: cert, key: key, serial: 1}, nil
+}
+
+// Pool returns a certificate pool containing only the CA.
+func (ca *CA) Pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Cert)
+	return pool
+}
+
+// Issue issues a certificate for hosts, which may be names or IP
+// addresses, valid from notBefore until notAfter. If client is true,
+// the certificate is for client authentication; otherwise, it is for
+// a server.
+func (ca *CA) Issue(hosts []string, notBefore, notAfter time.Time, client bool) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	ca.mu.Lock()
+	ca.serial++
+	serial := ca.serial
+	ca.mu.Unlock()
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	if client {
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	}
+
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	if len(hosts) > 0 {
+		template.Subject.CommonName = hosts[0]
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.Cert, &key.PublicKey, ca.key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}, nil
+}
+
+// TLSOptions controls the certificates used by NewTLSPair.
+type TLSOptions struct {
+	// ServerName is the name the client expects the peer to
+	// have. It defaults to "localhost".
+	ServerName string
+
+	// Hosts are the names in the peer's certificate. They default
+	// to ServerName; setting them to something else simulates a
+	// peer with the wrong hostname.
+	Hosts []string
+
+	// Expired issues the peer a certificate that has expired.
+	Expired bool
+
+	// ClientAuth makes the peer require a client certificate, and
+	// gives the client one issued by the CA.
+	ClientAuth bool
+
+	// NoClientCert stops the client from presenting a
+	// certificate, even if the peer requires one.
+	NoClientCert bool
+}
+
+// TLSPair is a TLS client and peer talking over a BufferConn pair.
+type TLSPair struct {
+	Client, Peer *tls.Conn
+
+	// CA is the certificate authority that issued the
+	// certificates. The client trusts it, as does the peer when
+	// client authentication is required.
+	CA *CA
+}
+
+// NewTLSPair creates a new CA and issues certificates according to
+// opts, which may be nil, then returns a TLS client and peer
+// connected over an in-memory BufferConn pair. Nothing is sent until
+// the handshake, which happens on the first read or write or may be
+// done explicitly with Handshake.
+func NewTLSPair(opts *TLSOptions) (*TLSPair, error) {
+	if opts == nil {
+		opts = &TLSOptions{}
+	}
+
+	serverName := opts.ServerName
+	if serverName == "" {
+		serverName = "localhost"
+	}
+
+	hosts := opts.Hosts
+	if len(hosts) == 0 {
+		hosts = []string{serverName}
+	}
+
+	ca, err := NewCA()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	notBefore, notAfter := now.Add(-time.Hour), now.Add(24*time.Hour)
+	if opts.Expired {
+		notBefore, notAfter = now.Add(-48*time.Hour), now.Add(-24*time.Hour)
+	}
+
+	peerCert, err := ca.Issue(hosts, notBefore, notAfter, false)
+	if err != nil {
+		return nil, err
+	}
+
+	clientConfig := &tls.Config{
+		RootCAs:    ca.Pool(),
+		ServerName: serverName,
+	}
+
+	peerConfig := &tls.Config{
+		Certificates: []tls.Certificate{peerCert},
+	}
+
+	if opts.ClientAuth {
+		peerConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		peerConfig.ClientCAs = ca.Pool()
+
+		if !opts.NoClientCert {
+			clientCert, err := ca.Issue([]string{"client"},
+				now.Add(-time.Hour), now.Add(24*time.Hour), true)
+			if err != nil {
+				return nil, err
+			}
+			clientConfig.Certificates = []tls.Certificate{clientCert}
+		}
+	}
+
+	client, peer := NewBufferConnPair()
+	return &TLSPair{
+		Client: tls.Client(client, clientConfig),
+		Peer:   tls.Server(peer, peerConfig),
+		CA:     ca,
+	}, nil
+}
+
+// Handshake runs the client and peer handshakes concurrently,
+// returning the error from each side. If either side fails, its
+// connection is closed so that the other side doesn't wait forever.
+func (tp *TLSPair) Handshake() (clientErr, peerErr error) {
+	wg := &sync.WaitGroup{}
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+		if clientErr = tp.Client.Handshake(); clientErr != nil {
+			tp.Client.NetConn().Close()
+		}
+	}()
+
+	go func() {
+		defer wg.Done()
+		if peerErr = tp.Peer.Handshake(); peerErr != nil {
+			tp.Peer.NetConn().Close()
+		}
+	}()
+
+	wg.Wait()
+	return clientErr, peerErr
+}
+
+// Close closes both connections.
+func (tp *TLSPair) Close() error {
+	tp.Client.NetConn().Close()
+	tp.Peer.NetConn().Close()
+	return nil
+}
@@ -0,0 +1,84 @@
+package testio
+
+import (
+	"crypto/x509"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestTLSPair(t *testing.T) {
+	tp, err := NewTLSPair(nil)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer tp.Close()
+
+	go func() {
+		tp.Peer.Write([]byte("hello"))
+	}()
+
+	p := make([]byte, 5)
+	_, err = io.ReadFull(tp.Client, p)
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if string(p) != "hello" {
+		t.Fatalf("expected 'hello', have '%s'", p)
+	}
+
+	tp, err = NewTLSPair(&TLSOptions{ClientAuth: true})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer tp.Close()
+
+	clientErr, peerErr := tp.Handshake()
+	if clientErr != nil || peerErr != nil {
+		t.Fatalf("handshake failed: client %v, peer %v", clientErr, peerErr)
+	}
+
+	state := tp.Peer.ConnectionState()
+	if len(state.PeerCertificates) == 0 {
+		t.Fatal("expected the client to present a certificate")
+	}
+}
+
+func TestTLSPairFailures(t *testing.T) {
+	tp, err := NewTLSPair(&TLSOptions{Expired: true})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer tp.Close()
+
+	clientErr, _ := tp.Handshake()
+	var invalid x509.CertificateInvalidError
+	if !errors.As(clientErr, &invalid) || invalid.Reason != x509.Expired {
+		t.Fatalf("expected an expired certificate error, have %v", clientErr)
+	}
+
+	tp, err = NewTLSPair(&TLSOptions{
+		ServerName: "api.example.com",
+		Hosts:      []string{"db.example.com"},
+	})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer tp.Close()
+
+	clientErr, _ = tp.Handshake()
+	var hostErr x509.HostnameError
+	if !errors.As(clientErr, &hostErr) {
+		t.Fatalf("expected a hostname error, have %v", clientErr)
+	}
+
+	tp, err = NewTLSPair(&TLSOptions{ClientAuth: true, NoClientCert: true})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer tp.Close()
+
+	_, peerErr := tp.Handshake()
+	if peerErr == nil {
+		t.Fatal("expected the peer to reject a client without a certificate")
+	}
+}